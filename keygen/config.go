@@ -0,0 +1,195 @@
+package keygen
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// InterfaceConfig holds the settings of a WireGuard interface
+type InterfaceConfig struct {
+	Address    []string // interface addresses with optional prefix length, eg: 10.0.0.2/24
+	ListenPort int
+	DNS        []string
+	Peers      []Peer // the other ends of the tunnel
+}
+
+// Peer holds the settings of a peer of a WireGuard interface
+type Peer struct {
+	PublicKey  string
+	Endpoint   string   // host:port, optional
+	AllowedIPs []string // addresses routed to the peer, with optional prefix length
+}
+
+// ParsePeer parses a peer given as its public key, optional endpoint and
+// allowed IPs, separated by commas, eg: <key>,vpn.example.com:51820,10.0.0.0/24
+func ParsePeer(s string) (Peer, error) {
+	fields := strings.Split(s, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	p := Peer{PublicKey: fields[0]}
+	if len(fields) > 1 {
+		p.Endpoint = fields[1]
+	}
+	if len(fields) > 2 {
+		p.AllowedIPs = fields[2:]
+	}
+
+	return p, p.Validate()
+}
+
+// Validate checks the public key, endpoint and allowed IPs of the peer are valid
+func (p Peer) Validate() error {
+	if b, err := base64.StdEncoding.DecodeString(p.PublicKey); err != nil || len(b) != KeySize {
+		return fmt.Errorf("invalid peer public key %q", p.PublicKey)
+	}
+	if p.Endpoint != "" {
+		host, port, err := net.SplitHostPort(p.Endpoint)
+		if _, perr := netip.ParseAddr(host); err != nil || port == "" || perr != nil && !isHostname(host) {
+			return fmt.Errorf("invalid peer endpoint %q", p.Endpoint)
+		}
+	}
+	for _, a := range p.AllowedIPs {
+		if _, err := parseAddress(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the addresses, DNS servers and listen port are valid
+func (cfg InterfaceConfig) Validate() error {
+	for _, a := range cfg.Address {
+		if _, err := parseAddress(a); err != nil {
+			return err
+		}
+	}
+	for _, d := range cfg.DNS {
+		if _, err := netip.ParseAddr(d); err != nil && !isHostname(d) {
+			return fmt.Errorf("invalid DNS server %q", d)
+		}
+	}
+	if cfg.ListenPort < 0 || cfg.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen port %d", cfg.ListenPort)
+	}
+	for _, p := range cfg.Peers {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AllowedIPs returns the interface addresses as host routes,
+// as the peer's AllowedIPs on the other end of the tunnel
+func (cfg InterfaceConfig) AllowedIPs() []string {
+	var ips []string
+	for _, a := range cfg.Address {
+		p, err := parseAddress(a)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, netip.PrefixFrom(p.Addr(), p.Addr().BitLen()).String())
+	}
+
+	return ips
+}
+
+// WGQuickConfig returns a wg-quick configuration file with an [Interface] section for the pair,
+// followed by a [Peer] section for each of the configured peers
+func (p Pair) WGQuickConfig(cfg InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "# PublicKey = %s\n", p.Public)
+	fmt.Fprintf(&b, "PrivateKey = %s\n", p.PrivateBytes())
+	if len(cfg.Address) > 0 {
+		fmt.Fprintf(&b, "Address = %s\n", strings.Join(cfg.Address, ", "))
+	}
+	if cfg.ListenPort > 0 {
+		fmt.Fprintf(&b, "ListenPort = %d\n", cfg.ListenPort)
+	}
+	if len(cfg.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(cfg.DNS, ", "))
+	}
+	for _, peer := range cfg.Peers {
+		fmt.Fprintf(&b, "\n[Peer]\nPublicKey = %s\n", peer.PublicKey)
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&b, "Endpoint = %s\n", peer.Endpoint)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(peer.AllowedIPs, ", "))
+		}
+	}
+
+	return b.String()
+}
+
+// PeerConfig returns a [Peer] section for the pair, to add to the configuration
+// of the other end of the tunnel
+func (p Pair) PeerConfig(cfg InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("[Peer]\n")
+	if p.Label != "" {
+		fmt.Fprintf(&b, "# %s\n", p.Label)
+	}
+	fmt.Fprintf(&b, "PublicKey = %s\n", p.Public)
+	if ips := cfg.AllowedIPs(); len(ips) > 0 {
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(ips, ", "))
+	}
+
+	return b.String()
+}
+
+// parseAddress parses an address with an optional prefix length
+func parseAddress(a string) (netip.Prefix, error) {
+	if strings.Contains(a, "/") {
+		p, err := netip.ParsePrefix(a)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q", a)
+		}
+		return p, nil
+	}
+	addr, err := netip.ParseAddr(a)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", a)
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// splitDNS splits DNS settings into the addresses of DNS servers and search domains
+func splitDNS(dns []string) (servers, domains []string) {
+	for _, d := range dns {
+		if _, err := netip.ParseAddr(d); err == nil {
+			servers = append(servers, d)
+		} else {
+			domains = append(domains, d)
+		}
+	}
+
+	return servers, domains
+}
+
+// isHostname returns true if s is a valid DNS search domain
+func isHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
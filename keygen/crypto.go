@@ -4,6 +4,7 @@ package keygen
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"io"
 
 	curve25519voi "github.com/oasisprotocol/curve25519-voi/curve"
 	scalar "github.com/oasisprotocol/curve25519-voi/curve/scalar"
@@ -22,15 +23,22 @@ type PrivateKey [KeySize]byte
 
 // NewPrivateKey generates a new curve25519 secret key (clamped).
 func newPrivateKey() (PrivateKey, error) {
+	return readPrivateKey(rand.Reader)
+}
+
+// readPrivateKey generates a new curve25519 secret key (clamped) from the random bytes of r
+func readPrivateKey(r io.Reader) (PrivateKey, error) {
 	var priv [KeySize]byte
-	_, err := rand.Read(priv[:])
+	_, err := io.ReadFull(r, priv[:])
 	if err != nil {
 		return PrivateKey{}, err
 	}
 	// Clamp as per RFC 7748
 	priv[0] &= 248
 	priv[31] = (priv[31] & 127) | 64
-	return PrivateKey(priv), nil
+	k := PrivateKey(priv)
+	clear(priv[:])
+	return k, nil
 }
 
 // Public computes the public key matching this curve25519 secret key using curve25519-voi.
@@ -57,3 +65,13 @@ func (k *PrivateKey) String() string {
 func (k Key) String() string {
 	return base64.StdEncoding.EncodeToString(k[:])
 }
+
+// sextet returns the 6-bit value of the i'th character of the base64-encoded key
+func (k *Key) sextet(i int) byte {
+	bit := i * 6
+	v := uint16(k[bit/8]) << 8
+	if bit/8+1 < KeySize {
+		v |= uint16(k[bit/8+1])
+	}
+	return byte(v>>(10-bit%8)) & 63
+}
@@ -1,7 +1,9 @@
 package keygen
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 	"math"
 	"runtime"
 	"sync"
@@ -18,23 +20,81 @@ func BenchmarkKeygenGenerationSpeed(b *testing.B) {
 	}
 }
 
+// BenchmarkKeygenGenerationSpeedBuffered benchmarks generating private keys from a
+// worker's buffered CSPRNG, as used by Find, for comparison with reading every key
+// from crypto/rand in BenchmarkKeygenGenerationSpeed.
+func BenchmarkKeygenGenerationSpeedBuffered(b *testing.B) {
+	r := BufferedCryptoSource{}.Reader(0)
+	for i := 0; i < b.N; i++ {
+		_, err := readPrivateKey(r)
+		if err != nil {
+			b.Fatalf("failed to generate private key: %v", err)
+		}
+	}
+}
+
+// BenchmarkIncrementalGeneration benchmarks the speed of generating public keys
+// by point addition with batched inversion. Each op is a single key, so the
+// result compares directly with BenchmarkKeygenGenerationSpeed plus Public().
+func BenchmarkIncrementalGeneration(b *testing.B) {
+	w, err := newWalker(rand.Reader)
+	if err != nil {
+		b.Fatalf("failed to create walker: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += incrementalBatchSize {
+		if err := w.next(); err != nil {
+			b.Fatalf("failed to generate batch: %v", err)
+		}
+	}
+}
+
 // BenchmarkCrunchThroughput benchmarks concurrent crunch() throughput including
-// key generation, base64 encoding, case conversion, and prefix matching.
+// key generation, base64 encoding, and case-insensitive prefix matching.
 // This reflects the worker pool design: fixed goroutines loop internally.
 func BenchmarkCrunchThroughput(b *testing.B) {
 	opts := Options{Cores: runtime.NumCPU(), CaseSensitive: false}
 	c := New(opts, 0)
 	// Use a prefix that will never match so the counter never saturates
-	c.WordMap["zzzz"] = &AtomicCounter{Value: math.MaxInt64}
+	c.AddMatcher(NewPrefixMatcher("zzzz", opts.CaseSensitive, math.MaxInt64))
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize)) // one per goroutine
 		for pb.Next() {
-			c.crunch(func(Pair) {}, buf)
+			c.crunch(rand.Reader, func(Pair) {}, buf)
 		}
 	})
 }
 
+// BenchmarkCheckPrefixes benchmarks matching a key against a growing number of
+// prefixes. As prefixes are matched in a single pass, the time per key should
+// remain roughly flat as the number of prefixes increases.
+func BenchmarkCheckPrefixes(b *testing.B) {
+	w, err := newWalker(rand.Reader)
+	if err != nil {
+		b.Fatalf("failed to create walker: %v", err)
+	}
+	if err := w.next(); err != nil {
+		b.Fatalf("failed to generate batch: %v", err)
+	}
+
+	for _, n := range []int{1, 10, 300} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			c := New(Options{}, 0)
+			for i := 0; i < n; i++ {
+				// six character prefixes which are unlikely to ever match
+				prefix := fmt.Sprintf("%c%c%c%c%c%c", base64Alphabet[i%64], base64Alphabet[i/64], 'z', 'z', 'z', 'z')
+				c.AddMatcher(NewPrefixMatcher(prefix, false, math.MaxInt64))
+			}
+			buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.check(&w.keys[i%incrementalBatchSize], func() PrivateKey { return w.private(0) }, func(Pair) {}, buf)
+			}
+		})
+	}
+}
+
 // BenchmarkGoroutinePerAttempt simulates the previous design where a new goroutine
 // was spawned for every single key attempt. Compare against BenchmarkCrunchThroughput
 // to quantify the goroutine lifecycle overhead that the worker pool eliminates.
@@ -0,0 +1,487 @@
+package keygen
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// workerReportInterval is how often remote workers report the number of keys they tried
+const workerReportInterval = time.Second
+
+// wireTimeout is how long the coordinator and workers wait for each other
+// before the connection is considered lost
+const wireTimeout = time.Minute
+
+// drainTimeout is how long the coordinator waits for the final stats of its
+// workers once it has stopped them
+const drainTimeout = 2 * time.Second
+
+// Messages between a coordinator and its workers
+const (
+	msgHello    = "hello"    // worker: connected, with its number of cores
+	msgSearches = "searches" // coordinator: the searches to run
+	msgMatch    = "match"    // worker: a matching key was found
+	msgStats    = "stats"    // worker: the number of keys tried so far
+	msgStop     = "stop"     // coordinator: every search is satisfied, or the search was cancelled
+)
+
+// wireMessage is a message between a coordinator and its workers, sent as a line of JSON
+type wireMessage struct {
+	Type     string       `json:"type"`
+	Cores    int          `json:"cores,omitempty"`
+	Searches []wireSearch `json:"searches,omitempty"`
+	Unique   bool         `json:"unique,omitempty"`
+	Match    *Pair        `json:"match,omitempty"`
+	Attempts uint64       `json:"attempts,omitempty"`
+}
+
+// wireSearch is a search sent to remote workers
+type wireSearch struct {
+	Term          string `json:"term"`
+	CaseSensitive bool   `json:"case_sensitive,omitempty"`
+	Mode          string `json:"mode"` // prefix, suffix, contains or regexp
+	Limit         int64  `json:"limit"`
+	Label         string `json:"label,omitempty"`
+}
+
+// toWire converts a matcher to a search which can be sent to remote workers
+func toWire(m Matcher) (wireSearch, error) {
+	s := wireSearch{Limit: max(m.Counter().Get(), 0), Label: labelOf(m)}
+	switch m := m.(type) {
+	case *PrefixMatcher:
+		s.Term, s.CaseSensitive, s.Mode = m.term, m.caseSensitive, MatchPrefix.String()
+	case *SuffixMatcher:
+		s.Term, s.CaseSensitive, s.Mode = m.term, m.caseSensitive, MatchSuffix.String()
+	case *ContainsMatcher:
+		s.Term, s.CaseSensitive, s.Mode = m.term, m.caseSensitive, MatchContains.String()
+	case *RegexpMatcher:
+		s.Term, s.CaseSensitive, s.Mode = m.re.String(), true, "regexp"
+	default:
+		return s, fmt.Errorf("search %q cannot be sent to remote workers", m.Name())
+	}
+
+	return s, nil
+}
+
+// matcher returns the Matcher for a search received from the coordinator
+func (s wireSearch) matcher() (Matcher, error) {
+	var m Matcher
+	if s.Mode == "regexp" {
+		re, err := regexp.Compile(s.Term)
+		if err != nil {
+			return nil, err
+		}
+		m = NewRegexpMatcher(re, s.Limit)
+	} else {
+		mode, err := ParseMatchMode(s.Mode)
+		if err != nil {
+			return nil, err
+		}
+		m = NewMatcher(s.Term, s.CaseSensitive, mode, s.Limit)
+	}
+	SetLabel(m, s.Label)
+
+	return m, nil
+}
+
+// Coordinator hands the searches of a Cruncher out to remote workers, which
+// connect over TCP and run them locally. The Cruncher's counters hold the quotas:
+// matches reported by workers are verified and counted against them, and every
+// worker is stopped once all searches are satisfied. The connection is not
+// encrypted, and matches include private keys, so it must only be used on a
+// trusted network.
+type Coordinator struct {
+	c *Cruncher
+
+	mu      sync.Mutex
+	started time.Time
+	stopped bool
+	drainBy time.Time // when connections are closed once stopped
+	workers []*remoteWorker
+	keys    map[string]bool // public keys reported, for Options.UniqueKeys
+}
+
+// remoteWorker is a worker connected to a coordinator
+type remoteWorker struct {
+	conn     net.Conn
+	cores    int
+	attempts atomic.Uint64
+
+	encMu sync.Mutex
+	enc   *json.Encoder
+}
+
+// send sends a message to the worker
+func (w *remoteWorker) send(msg wireMessage) error {
+	return sendWire(w.conn, &w.encMu, w.enc, msg)
+}
+
+// sendWire sends a message over conn, serialising concurrent sends with mu
+func sendWire(conn net.Conn, mu *sync.Mutex, enc *json.Encoder, msg wireMessage) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if err := conn.SetWriteDeadline(time.Now().Add(wireTimeout)); err != nil {
+		return err
+	}
+	return enc.Encode(msg)
+}
+
+// NewCoordinator returns a Coordinator for the searches of c. Only the
+// searches created by this package can be sent to remote workers.
+func NewCoordinator(c *Cruncher) (*Coordinator, error) {
+	for _, m := range c.matchers {
+		if _, err := toWire(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Coordinator{c: c, keys: make(map[string]bool)}, nil
+}
+
+// Serve accepts workers on l until every search is satisfied or ctx is done,
+// invoking cb for every match. The callback may be invoked concurrently.
+// l is closed when Serve returns. Like FindContext, it returns nil if all
+// searches were satisfied, otherwise a *FindError saying why it stopped.
+func (co *Coordinator) Serve(ctx context.Context, l net.Listener, cb func(match Pair)) error {
+	co.mu.Lock()
+	co.started = time.Now()
+	co.mu.Unlock()
+
+	done := make(chan struct{})
+	finish := sync.OnceFunc(func() { close(done) })
+	if co.completed() {
+		finish()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				co.handle(conn, cb, finish)
+			}()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	_ = l.Close()
+	co.stop()
+	wg.Wait()
+
+	if co.completed() {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &FindError{Status: TimedOut, Err: ctx.Err()}
+	}
+	return &FindError{Status: Cancelled, Err: ctx.Err()}
+}
+
+// handle runs the connection to a single worker
+func (co *Coordinator) handle(conn net.Conn, cb func(match Pair), finish func()) {
+	defer func() { _ = conn.Close() }()
+
+	dec := json.NewDecoder(conn)
+	var hello wireMessage
+	if err := conn.SetReadDeadline(time.Now().Add(wireTimeout)); err != nil {
+		return
+	}
+	if err := dec.Decode(&hello); err != nil || hello.Type != msgHello {
+		return
+	}
+
+	w := &remoteWorker{conn: conn, cores: hello.Cores, enc: json.NewEncoder(conn)}
+	co.mu.Lock()
+	if co.stopped {
+		co.mu.Unlock()
+		_ = w.send(wireMessage{Type: msgStop})
+		return
+	}
+	co.workers = append(co.workers, w)
+	searches := make([]wireSearch, len(co.c.matchers))
+	for i, m := range co.c.matchers {
+		searches[i], _ = toWire(m)
+	}
+	co.mu.Unlock()
+
+	if err := w.send(wireMessage{Type: msgSearches, Searches: searches, Unique: co.c.UniqueKeys}); err != nil {
+		return
+	}
+
+	for {
+		if err := co.extendDeadline(conn); err != nil {
+			return
+		}
+		var msg wireMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case msgStats:
+			w.attempts.Store(msg.Attempts)
+		case msgMatch:
+			if msg.Match != nil && co.accept(*msg.Match, cb) && co.completed() {
+				finish()
+			}
+		}
+	}
+}
+
+// extendDeadline sets the deadline for the worker's next message. Once the
+// coordinator is stopped, the worker only has until drainBy to send its final
+// stats, before its connection is closed.
+func (co *Coordinator) extendDeadline(conn net.Conn) error {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.stopped {
+		return conn.SetReadDeadline(co.drainBy)
+	}
+	return conn.SetReadDeadline(time.Now().Add(wireTimeout))
+}
+
+// stop tells every worker to stop. Workers send the number of keys they tried
+// before closing their connections, which are closed after drainTimeout otherwise.
+func (co *Coordinator) stop() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.stopped = true
+	co.drainBy = time.Now().Add(drainTimeout)
+	for _, w := range co.workers {
+		if err := w.send(wireMessage{Type: msgStop}); err != nil {
+			_ = w.conn.Close()
+			continue
+		}
+		_ = w.conn.SetReadDeadline(co.drainBy)
+	}
+}
+
+// accept verifies a match reported by a worker and counts it against the
+// quota of its search, returning true if it was accepted
+func (co *Coordinator) accept(p Pair, cb func(match Pair)) bool {
+	b, err := base64.StdEncoding.DecodeString(p.Private)
+	if err != nil || len(b) != KeySize {
+		return false
+	}
+	var k PrivateKey
+	copy(k[:], b)
+	clear(b)
+	defer k.Wipe()
+	pub := k.Public()
+	if pub.String() != p.Public {
+		return false
+	}
+	// hold the private key in locked memory, like the matches of a local search
+	p.Private = ""
+	p.secret = newSecret(&k)
+
+	if !co.count(p) {
+		p.Wipe()
+		return false
+	}
+	// the callback may call Stats, so it is invoked without holding co.mu
+	cb(p)
+
+	return true
+}
+
+// count counts a verified match against the quota of its search, returning
+// false if its search requires no more matches
+func (co *Coordinator) count(p Pair) bool {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.c.UniqueKeys && co.keys[p.Public] {
+		return false
+	}
+	for _, m := range co.c.matchers {
+		if m.Name() != p.Term || labelOf(m) != p.Label || m.Counter().Get() <= 0 || !m.Match(p.Public) {
+			continue
+		}
+		if pm, ok := m.(*PrefixMatcher); ok {
+			if !co.c.prefixes.dec(pm) {
+				continue
+			}
+		} else if m.Counter().Dec() < 0 {
+			continue
+		}
+		co.keys[p.Public] = true
+		return true
+	}
+
+	return false
+}
+
+// completed returns true once every search has been satisfied
+func (co *Coordinator) completed() bool {
+	for _, m := range co.c.matchers {
+		if m.Counter().Get() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns a snapshot of the progress of all workers, each worker's
+// attempts being the latest number it reported.
+// It is safe to call while Serve is running.
+func (co *Coordinator) Stats() Stats {
+	co.mu.Lock()
+	started, workers := co.started, slices.Clone(co.workers)
+	co.mu.Unlock()
+
+	var s Stats
+	if !started.IsZero() {
+		s.Elapsed = time.Since(started)
+	}
+	s.Workers = make([]uint64, len(workers))
+	for i, w := range workers {
+		s.Workers[i] = w.attempts.Load()
+		s.Attempts += s.Workers[i]
+	}
+	s.Terms = co.c.termStats(s.Attempts)
+
+	return s
+}
+
+// Cores returns the total number of CPU cores of the connected workers
+func (co *Coordinator) Cores() int {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	var n int
+	for _, w := range co.workers {
+		n += w.cores
+	}
+	return n
+}
+
+// RunWorker connects to the Coordinator at addr and searches for its terms
+// with the given options until the coordinator stops it or ctx is done.
+// It returns the number of keys tried.
+func RunWorker(ctx context.Context, addr string, options Options) (uint64, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	var encMu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(msg wireMessage) error {
+		return sendWire(conn, &encMu, enc, msg)
+	}
+	if err := send(wireMessage{Type: msgHello, Cores: options.Cores}); err != nil {
+		return 0, err
+	}
+
+	dec := json.NewDecoder(conn)
+	var msg wireMessage
+	if err := conn.SetReadDeadline(time.Now().Add(wireTimeout)); err != nil {
+		return 0, err
+	}
+	if err := dec.Decode(&msg); err != nil {
+		return 0, err
+	}
+	if msg.Type == msgStop {
+		return 0, nil
+	}
+	if msg.Type != msgSearches {
+		return 0, fmt.Errorf("unexpected %q message from coordinator", msg.Type)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return 0, err
+	}
+
+	options.UniqueKeys = msg.Unique
+	c := New(options, 0)
+	for _, s := range msg.Searches {
+		m, err := s.matcher()
+		if err != nil {
+			return 0, err
+		}
+		c.AddMatcher(m)
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// wait for the coordinator to stop the search
+	var stopped atomic.Bool
+	readErr := make(chan error, 1)
+	go func() {
+		defer cancel()
+		for {
+			var msg wireMessage
+			if err := dec.Decode(&msg); err != nil {
+				readErr <- err
+				return
+			}
+			if msg.Type == msgStop {
+				stopped.Store(true)
+				readErr <- nil
+				return
+			}
+		}
+	}()
+
+	// report progress
+	go func() {
+		t := time.NewTicker(workerReportInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := send(wireMessage{Type: msgStats, Attempts: c.Stats().Attempts}); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = c.FindContext(ctx, func(match Pair) {
+		err := send(wireMessage{Type: msgMatch, Match: &match})
+		match.Wipe()
+		if err != nil {
+			cancel()
+		}
+	})
+	attempts := c.Stats().Attempts
+	_ = send(wireMessage{Type: msgStats, Attempts: attempts})
+	if err == nil {
+		// every local quota is met, the coordinator stops the search once
+		// the other workers' matches are counted too
+		<-ctx.Done()
+	}
+
+	_ = conn.Close()
+	err = <-readErr
+	switch {
+	case stopped.Load():
+		return attempts, nil
+	case parent.Err() != nil:
+		return attempts, parent.Err()
+	}
+	return attempts, fmt.Errorf("lost connection to coordinator: %w", err)
+}
@@ -0,0 +1,84 @@
+package keygen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"filippo.io/age"
+)
+
+// Encryptor encrypts results with age (https://age-encryption.org), so
+// private keys can be stored and handed over safely
+type Encryptor struct {
+	recipients []age.Recipient
+}
+
+// NewEncryptor returns an Encryptor for one or more age X25519 recipients, eg: age1...
+func NewEncryptor(recipients ...string) (*Encryptor, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no age recipients")
+	}
+	e := &Encryptor{}
+	for _, s := range recipients {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient: %w", err)
+		}
+		e.recipients = append(e.recipients, r)
+	}
+
+	return e, nil
+}
+
+// NewPassphraseEncryptor returns an Encryptor for a passphrase. The key is derived
+// with scrypt, which takes around a second for every encryption.
+func NewPassphraseEncryptor(passphrase string) (*Encryptor, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encryptor{recipients: []age.Recipient{r}}, nil
+}
+
+// Encrypt returns data encrypted to the recipients, in age's binary format
+func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, e.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// PublicResult is a result without its private key
+type PublicResult struct {
+	Public string `json:"public"`
+	Term   string `json:"term,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+// PublicIndex returns the results without their private keys as indented JSON,
+// to be stored in plaintext alongside the encrypted results
+func PublicIndex(results []Pair) ([]byte, error) {
+	index := make([]PublicResult, 0, len(results))
+	for _, p := range results {
+		index = append(index, PublicResult{Public: p.Public, Term: p.Term, Label: p.Label})
+	}
+
+	return json.MarshalIndent(struct {
+		Results []PublicResult `json:"results"`
+	}{Results: index}, "", "  ")
+}
@@ -0,0 +1,136 @@
+package keygen
+
+import (
+	"io"
+
+	"filippo.io/edwards25519"
+	"filippo.io/edwards25519/field"
+)
+
+// incrementalBatchSize is the number of candidates converted from extended
+// Edwards coordinates to Montgomery form with a single field inversion
+const incrementalBatchSize = 256
+
+// incrementalMaxSteps is the number of steps a walker takes from its starting
+// scalar before it is reseeded
+const incrementalMaxSteps = 1 << 32
+
+// incrementalStep is the scalar added to the private key on every step.
+// Stepping by 8 keeps the low three bits cleared, so every candidate is a
+// correctly clamped private key.
+const incrementalStep = 8
+
+// walker generates candidate keys by repeatedly adding a fixed point to a
+// random starting point, rather than performing a full scalar multiplication
+// for every key. The private key of a candidate is only recovered when asked for.
+type walker struct {
+	rand   io.Reader           // random bytes for starting scalars
+	start  PrivateKey          // clamped starting scalar
+	offset uint64              // steps from start to the first key of the current batch
+	pos    uint64              // steps from start to point
+	point  *edwards25519.Point // start·B advanced by pos steps
+	step   *edwards25519.Point // incrementalStep·B
+
+	points [incrementalBatchSize]edwards25519.Point
+	num    [incrementalBatchSize]field.Element
+	den    [incrementalBatchSize]field.Element
+	prefix [incrementalBatchSize]field.Element
+	keys   [incrementalBatchSize]Key
+}
+
+// newWalker returns a walker starting from a fresh random scalar read from r
+func newWalker(r io.Reader) (*walker, error) {
+	var b [KeySize]byte
+	b[0] = incrementalStep
+	s, err := edwards25519.NewScalar().SetCanonicalBytes(b[:])
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walker{rand: r, step: edwards25519.NewIdentityPoint().ScalarBaseMult(s)}
+	if err := w.reseed(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// reseed picks a new random starting scalar. Scalars which would overflow
+// the clamped range within incrementalMaxSteps are rejected.
+func (w *walker) reseed() error {
+	for {
+		k, err := readPrivateKey(w.rand)
+		if err != nil {
+			return err
+		}
+		if end := addSteps(k, incrementalMaxSteps); end[31]&128 != 0 {
+			k.Wipe()
+			continue
+		}
+
+		s, err := edwards25519.NewScalar().SetBytesWithClamping(k[:])
+		if err != nil {
+			return err
+		}
+
+		w.start = k
+		w.pos = 0
+		w.point = edwards25519.NewIdentityPoint().ScalarBaseMult(s)
+		return nil
+	}
+}
+
+// next fills w.keys with the next batch of public keys
+func (w *walker) next() error {
+	if w.pos+incrementalBatchSize > incrementalMaxSteps {
+		if err := w.reseed(); err != nil {
+			return err
+		}
+	}
+
+	w.offset = w.pos
+	for i := range w.points {
+		w.points[i].Set(w.point)
+		w.point.Add(w.point, w.step)
+	}
+	w.pos += incrementalBatchSize
+
+	// The Montgomery u-coordinate is (Z+Y)/(Z-Y). All denominators are
+	// inverted at once using Montgomery's trick, trading one inversion per
+	// key for three multiplications.
+	var acc, inv field.Element
+	acc.One()
+	for i := range w.points {
+		_, y, z, _ := w.points[i].ExtendedCoordinates()
+		w.num[i].Add(z, y)
+		w.den[i].Subtract(z, y)
+		w.prefix[i].Set(&acc)
+		acc.Multiply(&acc, &w.den[i])
+	}
+	inv.Invert(&acc)
+	for i := len(w.points) - 1; i >= 0; i-- {
+		// prefix[i] * inv is the inverse of den[i]
+		w.prefix[i].Multiply(&w.prefix[i], &inv)
+		inv.Multiply(&inv, &w.den[i])
+		w.num[i].Multiply(&w.num[i], &w.prefix[i])
+		copy(w.keys[i][:], w.num[i].Bytes())
+	}
+
+	return nil
+}
+
+// private returns the private key of w.keys[i]
+func (w *walker) private(i int) PrivateKey {
+	return addSteps(w.start, w.offset+uint64(i))
+}
+
+// addSteps returns k advanced by n steps of incrementalStep
+func addSteps(k PrivateKey, n uint64) PrivateKey {
+	carry := n * incrementalStep
+	for i := 0; i < KeySize && carry > 0; i++ {
+		carry += uint64(k[i])
+		k[i] = byte(carry)
+		carry >>= 8
+	}
+	return k
+}
@@ -0,0 +1,88 @@
+package keygen
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// InventoryEntry is a peer requiring a vanity key
+type InventoryEntry struct {
+	Name       string   // name of the peer, reported as the label of its match
+	Term       string   // search term for the peer's public key
+	AllowedIPs []string // addresses of the peer
+}
+
+// ParseInventory reads a CSV inventory of peers with the columns name, search term
+// and allowed IPs, the latter separated by spaces or quoted commas. The allowed IPs
+// are optional. A header row, blank lines and lines starting with # are ignored.
+func ParseInventory(r io.Reader) ([]InventoryEntry, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var entries []InventoryEntry
+	names := make(map[string]int)
+	first := true
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		if first {
+			first = false
+			if isInventoryHeader(record) {
+				continue
+			}
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("line %d: expected name, search term and optional allowed IPs", line)
+		}
+
+		e := InventoryEntry{
+			Name: strings.TrimSpace(record[0]),
+			Term: strings.TrimSpace(record[1]),
+		}
+		if e.Name == "" {
+			return nil, fmt.Errorf("line %d: missing name", line)
+		}
+		if e.Term == "" {
+			return nil, fmt.Errorf("line %d: missing search term for %q", line, e.Name)
+		}
+		if prev, ok := names[e.Name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate name %q, first used on line %d", line, e.Name, prev)
+		}
+		names[e.Name] = line
+
+		if len(record) == 3 {
+			e.AllowedIPs = strings.FieldsFunc(record[2], func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			})
+			for _, ip := range e.AllowedIPs {
+				if _, err := parseAddress(ip); err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// isInventoryHeader returns true if the record is the CSV header row
+func isInventoryHeader(record []string) bool {
+	return len(record) >= 2 &&
+		strings.EqualFold(strings.TrimSpace(record[0]), "name") &&
+		strings.EqualFold(strings.TrimSpace(record[1]), "term")
+}
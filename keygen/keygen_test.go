@@ -1,80 +1,2009 @@
 package keygen
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"filippo.io/age"
 )
 
-// --- crypto.go ---
+// --- crypto.go ---
+
+func TestNewPrivateKey(t *testing.T) {
+	k, err := newPrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Clamping per RFC 7748
+	if k[0]&7 != 0 {
+		t.Errorf("low 3 bits of k[0] not cleared: %08b", k[0])
+	}
+	if k[31]&128 != 0 {
+		t.Errorf("high bit of k[31] not cleared: %08b", k[31])
+	}
+	if k[31]&64 == 0 {
+		t.Errorf("bit 6 of k[31] not set: %08b", k[31])
+	}
+}
+
+func TestPrivateKeyString(t *testing.T) {
+	k, err := newPrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := k.String()
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("private key string is not valid base64: %v", err)
+	}
+	if len(decoded) != KeySize {
+		t.Errorf("expected %d bytes, got %d", KeySize, len(decoded))
+	}
+}
+
+func TestPublicKeyString(t *testing.T) {
+	k, err := newPrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pub := k.Public()
+	s := pub.String()
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("public key string is not valid base64: %v", err)
+	}
+	if len(decoded) != KeySize {
+		t.Errorf("expected %d bytes, got %d", KeySize, len(decoded))
+	}
+}
+
+func TestPublicKeyDeterministic(t *testing.T) {
+	k, err := newPrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pub1 := k.Public()
+	pub2 := k.Public()
+	if pub1 != pub2 {
+		t.Error("Public() is not deterministic for the same private key")
+	}
+}
+
+func TestDistinctKeysGenerated(t *testing.T) {
+	k1, _ := newPrivateKey()
+	k2, _ := newPrivateKey()
+	if k1 == k2 {
+		t.Error("two generated private keys should not be identical")
+	}
+}
+
+// --- incremental.go ---
+
+func TestWalkerKeysMatchPrivate(t *testing.T) {
+	w, err := newWalker(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for batch := 0; batch < 3; batch++ {
+		if err := w.next(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, i := range []int{0, 1, incrementalBatchSize / 2, incrementalBatchSize - 1} {
+			k := w.private(i)
+			if k[0]&7 != 0 || k[31]&128 != 0 || k[31]&64 == 0 {
+				t.Fatalf("private key %d of batch %d is not clamped: %s", i, batch, k.String())
+			}
+			if k.Public() != w.keys[i] {
+				t.Errorf("public key %d of batch %d does not match its private key", i, batch)
+			}
+		}
+	}
+}
+
+func TestWalkerReseed(t *testing.T) {
+	w, err := newWalker(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	start := w.start
+	w.pos = incrementalMaxSteps - 1
+	if err := w.next(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.start == start {
+		t.Error("walker was not reseeded after reaching incrementalMaxSteps")
+	}
+	k := w.private(incrementalBatchSize - 1)
+	if k.Public() != w.keys[incrementalBatchSize-1] {
+		t.Error("public key does not match its private key after reseed")
+	}
+}
+
+func TestAddSteps(t *testing.T) {
+	var k PrivateKey
+	k[0] = 0xf8
+	k[1] = 0xff
+	got := addSteps(k, 1)
+	if got[0] != 0 || got[1] != 0 || got[2] != 1 {
+		t.Errorf("carry not propagated: %v", got[:3])
+	}
+}
+
+func TestFindIncremental(t *testing.T) {
+	opts := Options{Cores: 1, CaseSensitive: false, Incremental: true}
+	c := New(opts, 0)
+	c.AddMatcher(NewPrefixMatcher("a", opts.CaseSensitive, 2))
+
+	results := c.CollectToSlice()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	var keys []PrivateKey
+	for _, r := range results {
+		if !strings.HasPrefix(strings.ToLower(r.Public), "a") {
+			t.Errorf("unexpected public key: %s", r.Public)
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(r.PrivateBytes()))
+		if err != nil {
+			t.Fatalf("private key is not valid base64: %v", err)
+		}
+		k := PrivateKey(raw)
+		if k.Public().String() != r.Public {
+			t.Errorf("private key %s does not produce public key %s", r.PrivateBytes(), r.Public)
+		}
+		keys = append(keys, k)
+	}
+	// the walker is reseeded after a match, so the keys must not share a starting scalar
+	if string(keys[0][8:]) == string(keys[1][8:]) {
+		t.Errorf("private keys %s and %s are related", results[0].PrivateBytes(), results[1].PrivateBytes())
+	}
+}
+
+// --- distributed.go ---
+
+func TestCoordinator(t *testing.T) {
+	c := New(Options{}, 0)
+	c.AddMatcher(NewPrefixMatcher("a", false, 3), NewSuffixMatcher("A", true, 1))
+	re := NewRegexpMatcher(regexp.MustCompile(`(?i)^[0-9]`), 1)
+	re.SetLabel("digit")
+	c.AddMatcher(re)
+
+	co, err := NewCoordinator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var workerAttempts atomic.Uint64
+	workerErrs := make(chan error, 2)
+	for _, incremental := range []bool{false, true} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts, err := RunWorker(ctx, l.Addr().String(), Options{Cores: 1, Incremental: incremental})
+			workerAttempts.Add(attempts)
+			workerErrs <- err
+		}()
+	}
+
+	var mu sync.Mutex
+	var matches []Pair
+	err = co.Serve(ctx, l, func(p Pair) {
+		mu.Lock()
+		matches = append(matches, p)
+		mu.Unlock()
+	})
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	wg.Wait()
+	close(workerErrs)
+	for err := range workerErrs {
+		if err != nil {
+			t.Errorf("worker: %v", err)
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, p := range matches {
+		counts[p.Term]++
+		if !keyMatchesPrivate(t, p) {
+			t.Errorf("public key %s does not belong to private key", p.Public)
+		}
+	}
+	if counts["a"] != 3 || counts["A"] != 1 || counts["(?i)^[0-9]"] != 1 {
+		t.Errorf("unexpected matches per term %v", counts)
+	}
+
+	// a worker connecting after the search is satisfied is stopped straight away
+	s := co.Stats()
+	if len(s.Workers) == 0 || s.Terms[2].Label != "digit" || s.Terms[0].Found != 3 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if co.Cores() != len(s.Workers) {
+		t.Errorf("expected %d cores, got %d", len(s.Workers), co.Cores())
+	}
+	// the final stats of every worker are counted
+	if s.Attempts == 0 || s.Attempts != workerAttempts.Load() {
+		t.Errorf("coordinator counted %d attempts, workers reported %d", s.Attempts, workerAttempts.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	co.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := fmt.Sprintf("wireguard_vanity_keys_tried_total %d\n", s.Attempts)
+	if !strings.Contains(rec.Body.String(), want) || !strings.Contains(rec.Body.String(), fmt.Sprintf("wireguard_vanity_workers %d\n", len(s.Workers))) {
+		t.Errorf("unexpected metrics:\n%s", rec.Body.String())
+	}
+}
+
+// serveWithWorker runs a search of the coordinator with a single worker, failing
+// the test if it does not return in time
+func serveWithWorker(t *testing.T, co *Coordinator, cb func(Pair)) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go func() {
+		_, _ = RunWorker(ctx, l.Addr().String(), Options{Cores: 1})
+	}()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- co.Serve(ctx, l, cb)
+	}()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+}
+
+func TestCoordinatorStatsCallback(t *testing.T) {
+	c := New(Options{}, 0)
+	c.AddMatcher(NewPrefixMatcher("a", false, 2))
+	co, err := NewCoordinator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// callbacks such as --ndjson's read the coordinator's stats
+	var found atomic.Int64
+	serveWithWorker(t, co, func(Pair) {
+		co.Stats()
+		found.Add(1)
+	})
+	if found.Load() != 2 {
+		t.Errorf("expected 2 matches, got %d", found.Load())
+	}
+}
+
+func TestCoordinatorRejectsForgedMatches(t *testing.T) {
+	c := New(Options{}, 0)
+	c.AddMatcher(NewPrefixMatcher("abcdefgh", true, 1))
+	co, err := NewCoordinator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		enc := json.NewEncoder(conn)
+		_ = enc.Encode(wireMessage{Type: msgHello, Cores: 1})
+		var msg wireMessage
+		if err := json.NewDecoder(conn).Decode(&msg); err != nil || msg.Type != msgSearches {
+			return
+		}
+		// a public key which does not belong to the private key
+		k, _ := newPrivateKey()
+		_ = enc.Encode(wireMessage{Type: msgMatch, Match: &Pair{
+			Private: k.String(),
+			Public:  "abcdefghAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+			Term:    "abcdefgh",
+		}})
+		// a genuine key which does not match
+		pub := k.Public()
+		_ = enc.Encode(wireMessage{Type: msgMatch, Match: &Pair{Private: k.String(), Public: pub.String(), Term: "abcdefgh"}})
+		<-ctx.Done()
+	}()
+
+	var accepted int
+	err = co.Serve(ctx, l, func(Pair) { accepted++ })
+	var findErr *FindError
+	if !errors.As(err, &findErr) || findErr.Status != TimedOut {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if accepted != 0 {
+		t.Errorf("%d forged matches accepted", accepted)
+	}
+}
+
+func TestNewCoordinatorCustomMatcher(t *testing.T) {
+	c := New(Options{}, 0)
+	c.AddMatcher(&endsWithMatcher{suffix: "A=", counter: &AtomicCounter{Value: 1}})
+	if _, err := NewCoordinator(c); err == nil {
+		t.Error("expected an error for a matcher which cannot be sent to workers")
+	}
+}
+
+// --- encrypt.go ---
+
+// ageDecrypt decrypts data with the identity
+func ageDecrypt(t *testing.T, data []byte, id age.Identity) string {
+	t.Helper()
+	r, err := age.Decrypt(bytes.NewReader(data), id)
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestEncryptor(t *testing.T) {
+	id1, _ := age.GenerateX25519Identity()
+	id2, _ := age.GenerateX25519Identity()
+	e, err := NewEncryptor(id1.Recipient().String(), id2.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := e.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("expected the data to be encrypted")
+	}
+	for _, id := range []age.Identity{id1, id2} {
+		if got := ageDecrypt(t, data, id); got != "secret" {
+			t.Errorf("decrypted %q, want %q", got, "secret")
+		}
+	}
+
+	if _, err := NewEncryptor(); err == nil {
+		t.Error("expected an error without recipients")
+	}
+	if _, err := NewEncryptor("age1invalid"); err == nil {
+		t.Error("expected an error for an invalid recipient")
+	}
+	if _, err := NewPassphraseEncryptor(""); err == nil {
+		t.Error("expected an error for an empty passphrase")
+	}
+}
+
+func TestPassphraseEncryptor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scrypt is slow")
+	}
+	e, err := NewPassphraseEncryptor("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := e.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := age.NewScryptIdentity("correct horse")
+	if got := ageDecrypt(t, data, id); got != "secret" {
+		t.Errorf("decrypted %q, want %q", got, "secret")
+	}
+}
+
+func TestPublicIndex(t *testing.T) {
+	data, err := PublicIndex([]Pair{{Private: "priv", Public: "pub", Term: "a", Label: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "results": [
+    {
+      "public": "pub",
+      "term": "a",
+      "label": "alice"
+    }
+  ]
+}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	data, err = PublicIndex(nil)
+	if err != nil || string(data) != "{\n  \"results\": []\n}" {
+		t.Errorf("unexpected index without results: %s, %v", data, err)
+	}
+}
+
+func TestStreamWriterEncrypted(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	e, err := NewEncryptor(id.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewStreamWriter(&buf)
+	w.EncryptTo(e)
+	if err := w.Write(Match{Pair: Pair{Private: "priv", Public: "pub", Term: "a"}, Time: time.Unix(0, 0).UTC(), Attempts: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := line["private"]; ok || line["public"] != "pub" || line["term"] != "a" || line["attempts"] != 5.0 {
+		t.Errorf("unexpected line %s", buf.Bytes())
+	}
+	private, err := base64.StdEncoding.DecodeString(line["private_age"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ageDecrypt(t, private, id); got != "priv" {
+		t.Errorf("decrypted %q, want %q", got, "priv")
+	}
+}
+
+// --- inventory.go ---
+
+func TestParseInventory(t *testing.T) {
+	in := `name,term,allowed_ips
+# the office
+alice,alice/,10.0.0.2/32
+bob, bob+ ,"10.0.0.3/32,fd00::3/128"
+
+carol,^car[o0]l
+`
+	entries, err := ParseInventory(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Name != "alice" || entries[0].Term != "alice/" || strings.Join(entries[0].AllowedIPs, " ") != "10.0.0.2/32" {
+		t.Errorf("unexpected entry: %+v", entries[0])
+	}
+	if entries[1].Term != "bob+" || len(entries[1].AllowedIPs) != 2 {
+		t.Errorf("unexpected entry: %+v", entries[1])
+	}
+	if entries[2].Term != "^car[o0]l" || entries[2].AllowedIPs != nil {
+		t.Errorf("unexpected entry: %+v", entries[2])
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice\n", "line 1: expected name"},
+		{"alice,a\nalice,b\n", "line 2: duplicate name \"alice\", first used on line 1"},
+		{"alice,a,10.0.0.300\n", "line 1: invalid address"},
+		{"name,term\n,a\n", "line 2: missing name"},
+		{"alice,\n", "line 1: missing search term"},
+	}
+	for _, tt := range tests {
+		_, err := ParseInventory(strings.NewReader(tt.in))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseInventory(%q) = %v, want error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestFindInventoryLabels(t *testing.T) {
+	c := New(Options{Cores: 2, Incremental: true, UniqueKeys: true}, 0)
+	for _, e := range []InventoryEntry{{Name: "alice", Term: "a"}, {Name: "bob", Term: "a"}, {Name: "carol", Term: "c"}} {
+		m := NewPrefixMatcher(e.Term, false, 1)
+		SetLabel(m, e.Name)
+		c.AddMatcher(m)
+	}
+
+	results := c.CollectToSlice()
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	seen := make(map[string]string)
+	for _, r := range results {
+		if !strings.HasPrefix(strings.ToLower(r.Public), r.Term) {
+			t.Errorf("public key %q does not match term %q", r.Public, r.Term)
+		}
+		if other, ok := seen[r.Public]; ok {
+			t.Errorf("key %s reported for both %s and %s", r.Public, other, r.Label)
+		}
+		seen[r.Public] = r.Label
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected a result for each label, got %v", seen)
+	}
+}
+
+// --- matcher.go ---
+
+func TestPrefixMatcher(t *testing.T) {
+	tests := []struct {
+		prefix        string
+		caseSensitive bool
+		key           string
+		want          bool
+	}{
+		{"abc", false, "AbCdef=", true},
+		{"ABC", false, "abcdef=", true},
+		{"abc", true, "AbCdef=", false},
+		{"AbC", true, "AbCdef=", true},
+		{"abc", false, "ab", false},
+		{"a/+", false, "A/+", true},
+	}
+	for _, tt := range tests {
+		m := NewPrefixMatcher(tt.prefix, tt.caseSensitive, 1)
+		if got := m.Match(tt.key); got != tt.want {
+			t.Errorf("NewPrefixMatcher(%q, %v).Match(%q) = %v, want %v", tt.prefix, tt.caseSensitive, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixMatcherMatchKey(t *testing.T) {
+	prefixes := []string{"a", "A", "ab", "aB/", "Zz+9", "0123", "q/+q/", "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQ"}
+	for i := 0; i < 200; i++ {
+		k, err := newPrivateKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pub := k.Public()
+		encoded := pub.String()
+		// always include a prefix of the key itself so matches are exercised
+		for _, prefix := range append(prefixes, encoded[:1+i%10], encoded[:43]) {
+			for _, caseSensitive := range []bool{true, false} {
+				m := NewPrefixMatcher(prefix, caseSensitive, 1)
+				if m.MatchKey(&pub) != m.Match(encoded) {
+					t.Fatalf("MatchKey and Match disagree for %q (case-sensitive %v) on %s", prefix, caseSensitive, encoded)
+				}
+			}
+		}
+	}
+
+	// the 43rd character can only take values with the lowest 2 bits unset
+	if NewPrefixMatcher(strings.Repeat("A", 42)+"B", true, 1).allowed != nil {
+		t.Error("expected prefix with impossible final character to be invalid")
+	}
+	if NewPrefixMatcher("ab=", false, 1).allowed != nil {
+		t.Error("expected prefix with invalid character to be invalid")
+	}
+}
+
+func TestSuffixAndContainsMatchers(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		k, err := newPrivateKey()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pub := k.Public()
+		encoded := pub.String()
+		terms := []string{"a", "A", "ab", "w", encoded[39:43], encoded[10:14], encoded[i%40 : i%40+3]}
+		for _, term := range terms {
+			for _, caseSensitive := range []bool{true, false} {
+				suffix := NewSuffixMatcher(term, caseSensitive, 1)
+				want := strings.HasSuffix(encoded, term+"=")
+				if !caseSensitive {
+					want = strings.HasSuffix(strings.ToLower(encoded), strings.ToLower(term)+"=")
+				}
+				if suffix.MatchKey(&pub) != want || suffix.Match(encoded) != want {
+					t.Fatalf("suffix %q (case-sensitive %v) on %s: want %v", term, caseSensitive, encoded, want)
+				}
+
+				contains := NewContainsMatcher(term, caseSensitive, 1)
+				want = strings.Contains(encoded, term)
+				if !caseSensitive {
+					want = strings.Contains(strings.ToLower(encoded), strings.ToLower(term))
+				}
+				if contains.MatchKey(&pub) != want || contains.Match(encoded) != want {
+					t.Fatalf("contains %q (case-sensitive %v) on %s: want %v", term, caseSensitive, encoded, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFindSuffix(t *testing.T) {
+	c := New(Options{Cores: 2, Incremental: true}, 0)
+	c.AddMatcher(NewMatcher("k", false, MatchSuffix, 1))
+
+	results := c.CollectToSlice()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if !strings.HasSuffix(results[0].Public, "k=") {
+		t.Errorf("public key %q does not end with 'k='", results[0].Public)
+	}
+}
+
+// endsWithMatcher is a Matcher implemented outside of the package's built-in matchers
+type endsWithMatcher struct {
+	suffix  string
+	counter *AtomicCounter
+}
+
+func (m *endsWithMatcher) Match(key string) bool   { return strings.HasSuffix(key, m.suffix) }
+func (m *endsWithMatcher) Name() string            { return m.suffix }
+func (m *endsWithMatcher) Counter() *AtomicCounter { return m.counter }
+
+func TestCustomMatcher(t *testing.T) {
+	opts := Options{Cores: 2}
+	c := New(opts, 0)
+	m := &endsWithMatcher{suffix: "A=", counter: &AtomicCounter{Value: 1}}
+	c.AddMatcher(m)
+
+	results := c.CollectToSlice()
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if !strings.HasSuffix(results[0].Public, "A=") {
+		t.Errorf("public key %q does not end with 'A='", results[0].Public)
+	}
+	if m.Counter().Get() != 0 {
+		t.Errorf("expected counter to be exhausted, got %d", m.Counter().Get())
+	}
+}
+
+// --- metrics.go ---
+
+func TestMetricsHandler(t *testing.T) {
+	c := New(Options{Cores: 2}, 0)
+	m := NewPrefixMatcher("a", false, 2)
+	m.SetLabel(`peer "one"`)
+	c.AddMatcher(m, NewPrefixMatcher("abcdefgh", false, 1))
+
+	srv := httptest.NewServer(c.MetricsHandler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_ = c.FindContext(ctx, func(Pair) {})
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	samples := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		samples[line[:i]] = line[i+1:]
+	}
+
+	s := c.Stats()
+	want := map[string]string{
+		"wireguard_vanity_keys_tried_total":                                            fmt.Sprint(s.Attempts),
+		`wireguard_vanity_worker_keys_tried_total{worker="0"}`:                         fmt.Sprint(s.Workers[0]),
+		"wireguard_vanity_workers":                                                     "2",
+		`wireguard_vanity_matches_total{search="0",term="a",label="peer \"one\""}`:     "2",
+		`wireguard_vanity_remaining_matches{search="0",term="a",label="peer \"one\""}`: "0",
+		`wireguard_vanity_matches_total{search="1",term="abcdefgh",label=""}`:          "0",
+		`wireguard_vanity_remaining_matches{search="1",term="abcdefgh",label=""}`:      "1",
+	}
+	for k, v := range want {
+		if got, ok := samples[k]; !ok || got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if rate, err := strconv.ParseFloat(samples["wireguard_vanity_keys_per_second"], 64); err != nil || rate <= 0 {
+		t.Errorf("keys per second = %q", samples["wireguard_vanity_keys_per_second"])
+	}
+
+	resp, err = http.Post(srv.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+// --- regexprob.go ---
+
+func TestRegexProbability(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{`^a`, 1.0 / 64},
+		{`(?i)^a`, 1.0 / 32},
+		{`^ab`, 1.0 / 4096},
+		{`^[ab]`, 2.0 / 64},
+		{`A=$`, 1.0 / 16},
+		{`x=$`, 0},
+		{`.*`, 1},
+		{`^(a|b)`, 2.0 / 64},
+		{`=`, 1},
+		{`^$`, 0},
+		{`(?i)^abc`, 1.0 / 32768},
+	}
+	for _, tt := range tests {
+		got, err := RegexProbability(tt.expr)
+		if err != nil {
+			t.Errorf("RegexProbability(%q): %v", tt.expr, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("RegexProbability(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	// unanchored literals agree with the literal calculation
+	for _, term := range []string{"ab", "xyz", "a+b"} {
+		p, err := RegexProbability("(?i)" + regexp.QuoteMeta(term))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := oneIn(p)
+		want := CalculateProbability(term, false, MatchContains)
+		if n != want {
+			t.Errorf("regex probability for %q: 1 in %v, want 1 in %v", term, n, want)
+		}
+	}
+
+	if _, err := RegexProbability(`(`); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+}
+
+func TestRegexProbabilitySampled(t *testing.T) {
+	// compare against the proportion of random keys matching
+	rng := mathrand.New(mathrand.NewPCG(1, 2))
+	const samples = 100000
+	for _, expr := range []string{`(?i)a.*b`, `\bab`, `^[0-9]+[a-z]`, `(?i)[xy]{2}`} {
+		re := regexp.MustCompile(expr)
+		var hits int
+		for i := 0; i < samples; i++ {
+			var k Key
+			for j := range k {
+				k[j] = byte(rng.Uint32())
+			}
+			k[31] &= 127
+			if re.MatchString(k.String()) {
+				hits++
+			}
+		}
+
+		p, err := RegexProbability(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := float64(hits) / samples
+		// allow five standard deviations
+		if tol := 5 * math.Sqrt(p*(1-p)/samples); math.Abs(got-p) > tol {
+			t.Errorf("RegexProbability(%q) = %v, sampled %v", expr, p, got)
+		}
+	}
+}
+
+func TestCalculateRegexProbability(t *testing.T) {
+	n, err := CalculateRegexProbability(`^ab`)
+	if err != nil || n != 4096 {
+		t.Errorf("CalculateRegexProbability(^ab) = %v, %v, want 4096", n, err)
+	}
+	n, err = CalculateRegexProbability(`b=$`)
+	if err != nil || n != 0 {
+		t.Errorf("CalculateRegexProbability(b=$) = %v, %v, want 0", n, err)
+	}
+}
+
+// --- search.go ---
+
+func TestNewSearch(t *testing.T) {
+	tests := []struct {
+		term          string
+		caseSensitive bool
+		mode          MatchMode
+		name          string // "" if invalid
+	}{
+		{"abc", false, MatchPrefix, "abc"},
+		{"ABC", false, MatchPrefix, "abc"},
+		{"abc", true, MatchSuffix, ""},
+		{"ab!", false, MatchPrefix, ""},
+		{"^ab", false, MatchPrefix, "(?i)^ab"},
+		{"^ab", true, MatchPrefix, "^ab"},
+		{".*ab.*", false, MatchPrefix, "(?i)ab"},
+		{"^a{44}", true, MatchPrefix, ""}, // longer than a key
+		{"^(ab", false, MatchPrefix, ""},
+	}
+	for _, tt := range tests {
+		m, err := NewSearch(tt.term, tt.caseSensitive, tt.mode, 1)
+		if tt.name == "" {
+			if err == nil {
+				t.Errorf("NewSearch(%q): expected an error", tt.term)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewSearch(%q): %v", tt.term, err)
+			continue
+		}
+		if m.Name() != tt.name {
+			t.Errorf("NewSearch(%q).Name() = %q, want %q", tt.term, m.Name(), tt.name)
+		}
+	}
+}
+
+// --- secret.go ---
+
+func TestFreeSecret(t *testing.T) {
+	b := allocSecret()
+	b[0] = 'x'
+	freeSecret(b)
+	if *b != [encodedKeySize]byte{} {
+		t.Error("expected a freed secret to be overwritten")
+	}
+}
+
+func TestPairWipe(t *testing.T) {
+	k, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := k.Public()
+	p := newPair(&k, &pub, NewPrefixMatcher("a", false, 1))
+	// the private key is only held in locked memory
+	if p.Private != "" || string(p.PrivateBytes()) != k.String() || p.Public != pub.String() || p.Term != "a" {
+		t.Fatalf("unexpected pair: %+v", p)
+	}
+	if string(p.Secret().Bytes()) != k.String() {
+		t.Errorf("unexpected secret %q", p.Secret().Bytes())
+	}
+	data, err := json.Marshal(p)
+	if err != nil || !strings.Contains(string(data), `"private":"`+k.String()+`"`) {
+		t.Errorf("expected the private key in JSON, got %s, %v", data, err)
+	}
+	data, err = json.Marshal(Match{Pair: p, Attempts: 7})
+	if err != nil || !strings.Contains(string(data), `"private":"`+k.String()+`"`) || !strings.Contains(string(data), `"attempts":7`) {
+		t.Errorf("expected the private key and attempts in JSON, got %s, %v", data, err)
+	}
+
+	cp := p
+	secret := p.secret
+	p.Wipe()
+	if p.Private != "" || p.Secret() != nil || p.PrivateBytes() != nil || p.Public != pub.String() {
+		t.Errorf("unexpected pair after Wipe: %+v", p)
+	}
+	if *secret.b != [encodedKeySize]byte{} || secret.Bytes() != nil {
+		t.Error("expected the private key to be overwritten")
+	}
+	// copies share the wiped secret, and can still be used
+	if got := fmt.Sprintf("%q %s", cp.PrivateBytes(), cp.Public); got != `"" `+pub.String() {
+		t.Errorf("unexpected copy after Wipe %s", got)
+	}
+	if data, err := json.Marshal(cp); err != nil || !strings.Contains(string(data), `"private":""`) {
+		t.Errorf("expected no private key in JSON, got %s, %v", data, err)
+	}
+	// wiping twice, or a pair without locked memory, is harmless
+	p.Wipe()
+	cp.Wipe()
+	q := Pair{Private: k.String()}
+	if string(q.PrivateBytes()) != k.String() {
+		t.Errorf("unexpected private key %q", q.PrivateBytes())
+	}
+	q.Wipe()
+	if q.Private != "" || q.PrivateBytes() != nil {
+		t.Errorf("expected Private to be cleared, got %q", q.Private)
+	}
+
+	// copies may be wiped and read concurrently
+	p = newPair(&k, &pub, NewPrefixMatcher("a", false, 1))
+	var wg sync.WaitGroup
+	for i := range 4 {
+		cp := p
+		wg.Go(func() {
+			if i%2 == 0 {
+				cp.Wipe()
+			} else {
+				_ = cp.PrivateBytes()
+			}
+		})
+	}
+	wg.Wait()
+
+	k.Wipe()
+	if k != (PrivateKey{}) {
+		t.Error("expected the private key to be zeroed")
+	}
+}
+
+// --- service.go ---
+
+// submitJob submits a job to the service, returning its status
+func submitJob(t *testing.T, url string, req JobRequest) (JobStatus, int) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url+"/jobs", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var st JobStatus
+	if resp.StatusCode == http.StatusCreated {
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st, resp.StatusCode
+}
+
+// jobStatus polls the status of a job
+func jobStatus(t *testing.T, url, id string) JobStatus {
+	t.Helper()
+	resp, err := http.Get(url + "/jobs/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var st JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// cancelJob cancels a job, returning its status
+func cancelJob(t *testing.T, url, id string) JobStatus {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodDelete, url+"/jobs/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var st JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestServiceJob(t *testing.T) {
+	svc := NewService(2)
+	defer svc.Close()
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	st, code := submitJob(t, srv.URL, JobRequest{Terms: []string{"a", "^b"}, Limit: 2, Cores: 2})
+	if code != http.StatusCreated {
+		t.Fatalf("submit returned %d", code)
+	}
+
+	// stream the results until the job finishes
+	resp, err := http.Get(srv.URL + "/jobs/" + st.ID + "/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	dec := json.NewDecoder(resp.Body)
+	var streamed []Pair
+	for {
+		var p Pair
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		streamed = append(streamed, p)
+	}
+	if len(streamed) != 4 {
+		t.Fatalf("expected 4 streamed results, got %d", len(streamed))
+	}
+
+	st = jobStatus(t, srv.URL, st.ID)
+	if st.State != JobFinished || len(st.Results) != 4 || st.Attempts == 0 || st.Started == nil || st.Finished == nil {
+		t.Errorf("unexpected status %+v", st)
+	}
+	for _, p := range st.Results {
+		if !keyMatchesPrivate(t, p) {
+			t.Errorf("public key %s does not belong to private key", p.Public)
+		}
+		if p.Term == "a" && !strings.HasPrefix(strings.ToLower(p.Public), "a") ||
+			p.Term == "(?i)^b" && !strings.HasPrefix(strings.ToLower(p.Public), "b") {
+			t.Errorf("result %+v does not match its term", p)
+		}
+	}
+}
+
+// keyMatchesPrivate returns true if the pair's public key is derived from its private key
+func keyMatchesPrivate(t *testing.T, p Pair) bool {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(string(p.PrivateBytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var k PrivateKey
+	copy(k[:], b)
+	pub := k.Public()
+	return pub.String() == p.Public
+}
+
+func TestServiceCancelAndQueue(t *testing.T) {
+	svc := NewService(1)
+	defer svc.Close()
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	first, _ := submitJob(t, srv.URL, JobRequest{Terms: []string{"abcdefghij"}})
+	second, _ := submitJob(t, srv.URL, JobRequest{Terms: []string{"a"}})
+
+	// the second job waits for the only core
+	time.Sleep(100 * time.Millisecond)
+	if st := jobStatus(t, srv.URL, first.ID); st.State != JobRunning {
+		t.Errorf("first job is %s, want running", st.State)
+	}
+	if st := jobStatus(t, srv.URL, second.ID); st.State != JobQueued {
+		t.Errorf("second job is %s, want queued", st.State)
+	}
+
+	if st := cancelJob(t, srv.URL, first.ID); st.State != JobCancelled {
+		t.Errorf("cancelled job is %s", st.State)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		st := jobStatus(t, srv.URL, second.ID)
+		if st.State == JobFinished {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("second job is still %s", st.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a queued job can be cancelled too
+	third, _ := submitJob(t, srv.URL, JobRequest{Terms: []string{"abcdefghij"}})
+	fourth, _ := submitJob(t, srv.URL, JobRequest{Terms: []string{"a"}})
+	if st := cancelJob(t, srv.URL, fourth.ID); st.State != JobCancelled || st.Started != nil {
+		t.Errorf("cancelled queued job: %+v", st)
+	}
+	cancelJob(t, srv.URL, third.ID)
+
+	resp, err := http.Get(srv.URL + "/jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var list []JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 || list[0].ID != first.ID || list[3].ID != fourth.ID {
+		t.Errorf("unexpected job list %+v", list)
+	}
+}
+
+func TestServiceTimeout(t *testing.T) {
+	svc := NewService(1)
+	defer svc.Close()
+
+	st, err := svc.Submit(JobRequest{Terms: []string{"abcdefghij"}, Timeout: "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for st.State != JobTimedOut && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		st, _ = svc.Job(st.ID)
+	}
+	if st.State != JobTimedOut {
+		t.Errorf("job is %s, want timed out", st.State)
+	}
+}
+
+func TestServiceInvalidRequests(t *testing.T) {
+	svc := NewService(2)
+	defer svc.Close()
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	for _, req := range []JobRequest{
+		{},
+		{Terms: []string{"a!"}},
+		{Terms: []string{"a"}, Cores: 3},
+		{Terms: []string{"a"}, Mode: "middle"},
+		{Terms: []string{"a"}, Timeout: "soon"},
+		{Terms: []string{"abc"}, Mode: "suffix", CaseSensitive: true},
+	} {
+		if _, code := submitJob(t, srv.URL, req); code != http.StatusBadRequest {
+			t.Errorf("request %+v returned %d, want %d", req, code, http.StatusBadRequest)
+		}
+	}
+
+	resp, err := http.Post(srv.URL+"/jobs", "application/json", strings.NewReader(`{"terms": ["a"], "bogus": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown field returned %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/jobs/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing job returned %d", resp.StatusCode)
+	}
+}
+
+func TestCoreLimiter(t *testing.T) {
+	l := coreLimiter{free: 3}
+	ctx := context.Background()
+	if err := l.acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// a job needing 2 cores waits, and so does a later one needing 1,
+	// as cores are granted in turn
+	granted := make(chan int, 2)
+	go func() {
+		_ = l.acquire(ctx, 2)
+		granted <- 2
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		_ = l.acquire(ctx, 1)
+		granted <- 1
+	}()
+	select {
+	case n := <-granted:
+		t.Fatalf("%d cores granted before any were released", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	l.release(1)
+	if n := <-granted; n != 2 {
+		t.Errorf("granted %d cores, want 2", n)
+	}
+	select {
+	case <-granted:
+		t.Fatal("cores granted before they were released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release(1)
+	if n := <-granted; n != 1 {
+		t.Errorf("granted %d cores, want 1", n)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.acquire(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire with a cancelled context returned %v", err)
+	}
+	if l.free != 0 || len(l.waiters) != 0 {
+		t.Errorf("free %d, waiters %d", l.free, len(l.waiters))
+	}
+}
+
+// --- source.go ---
+
+func TestInsecureSeededSource(t *testing.T) {
+	read := func(seed uint64, worker int) []byte {
+		b := make([]byte, 64)
+		if _, err := io.ReadFull(InsecureSeededSource{Seed: seed}.Reader(worker), b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	if !bytes.Equal(read(1, 0), read(1, 0)) {
+		t.Error("expected the same bytes for the same seed and worker")
+	}
+	if bytes.Equal(read(1, 0), read(1, 1)) {
+		t.Error("expected different bytes for different workers")
+	}
+	if bytes.Equal(read(1, 0), read(2, 0)) {
+		t.Error("expected different bytes for different seeds")
+	}
+}
+
+func TestBufferedCryptoSource(t *testing.T) {
+	r := BufferedCryptoSource{}.Reader(0).(*csprng)
+	a := make([]byte, KeySize)
+	b := make([]byte, KeySize)
+	if _, err := io.ReadFull(r, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(BufferedCryptoSource{}.Reader(0), b); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("expected each reader to be seeded separately")
+	}
+
+	// reseeded once the bytes are used up
+	rng := r.rng
+	state, _ := rng.MarshalBinary()
+	r.remaining = KeySize - 1
+	if _, err := io.ReadFull(r, a); err != nil {
+		t.Fatal(err)
+	}
+	if r.rng != rng || r.remaining != csprngReseedBytes-KeySize {
+		t.Errorf("expected the generator to be reseeded in place, %d bytes remaining", r.remaining)
+	}
+	b = make([]byte, KeySize)
+	if err := rng.UnmarshalBinary(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rng.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, b) {
+		t.Error("expected different bytes after reseeding")
+	}
+}
+
+func TestFindGolden(t *testing.T) {
+	tests := []struct {
+		incremental bool
+		attempts    uint64
+		want        []Pair
+	}{
+		{false, 625, []Pair{
+			{Private: "COBidgYf5XbXj87hpX7uvUcyrlhBkv26NpaYlsCz0G0=", Public: "QERfh8Gef1miBSr66MUeKBpK91wGR+ofRDLuV4Ip+RQ=", Term: "Q"},
+			{Private: "SJ1iugaaFeqg/op/e6Tywy+hjpheeS30R4nwqcSgEEY=", Public: "ABBLntUOjihw5Ffv7fglHAAG5bxMYUR0A8laVcKmEww=", Term: "ab"},
+			{Private: "SP9k2GG19vLTSf2yVHCjf1Pqb7EP78BdB9kFKx35fH8=", Public: "ab5Kf2f6G9HEnjI+FdsEBhQTspMkG1PB5VqgtB0o4XU=", Term: "ab"},
+		}},
+		{true, 938, []Pair{
+			{Private: "sDAfuNgpeNrwB7BWFJafNAPQBiZz9VlElXmNNAwKF2g=", Public: "sI8zSBGKl8ldyJoI+VtYsJ+MhacZfq5XKG04ADK7tSQ=", Term: "Q"},
+			{Private: "KOA7nSCdL8dNkrShNR8qCTAlA96dVjJhj21ixjQWnFI=", Public: "AB/Nhh8yIrIcIGNJ/U9HYzs9kbidVrsdg3T6JMqBmR4=", Term: "ab"},
+			{Private: "aJf8ZJpXjApTBwT6o1IXrB82VH6rjD6YED+Ak5zmJEo=", Public: "Ab1o+mVnAaiJhSeu0mKvTVr2LNEX5I6SiWPJZGXYOhg=", Term: "ab"},
+		}},
+	}
+	for _, tt := range tests {
+		c := New(Options{Cores: 1, Incremental: tt.incremental, Source: InsecureSeededSource{Seed: 42}}, 0)
+		c.AddMatcher(NewPrefixMatcher("ab", false, 2), NewSuffixMatcher("Q", true, 1))
+
+		results := c.CollectToSlice()
+		if len(results) != len(tt.want) {
+			t.Fatalf("incremental=%v: expected %d results, got %+v", tt.incremental, len(tt.want), results)
+		}
+		for i, want := range tt.want {
+			got := results[i]
+			got.Private = string(got.PrivateBytes())
+			got.secret = nil
+			if got != want {
+				t.Errorf("incremental=%v: result %d = %+v, want %+v", tt.incremental, i, got, want)
+			}
+			if !keyMatchesPrivate(t, results[i]) {
+				t.Errorf("incremental=%v: private key does not match public key %s", tt.incremental, results[i].Public)
+			}
+			results[i].Wipe()
+		}
+		if got := c.Stats().Attempts; got != tt.attempts {
+			t.Errorf("incremental=%v: expected %d attempts, got %d", tt.incremental, tt.attempts, got)
+		}
+	}
+}
+
+// --- stats.go ---
+
+func TestStats(t *testing.T) {
+	for _, incremental := range []bool{false, true} {
+		c := New(Options{Cores: 2, Incremental: incremental}, 0)
+		prefix := NewPrefixMatcher("a", false, 2)
+		re := NewRegexpMatcher(regexp.MustCompile(`^[0-9]`), 1)
+		re.SetLabel("digit")
+		c.AddMatcher(prefix, re)
+
+		if s := c.Stats(); s.Attempts != 0 || s.Elapsed != 0 {
+			t.Errorf("stats before searching: %+v", s)
+		}
+
+		if err := c.FindContext(context.Background(), func(Pair) {}); err != nil {
+			t.Fatal(err)
+		}
+
+		s := c.Stats()
+		if len(s.Workers) != 2 {
+			t.Fatalf("expected 2 workers, got %d", len(s.Workers))
+		}
+		if s.Attempts == 0 || s.Workers[0]+s.Workers[1] != s.Attempts {
+			t.Errorf("attempts %d do not add up to workers %v", s.Attempts, s.Workers)
+		}
+		if s.Elapsed <= 0 || s.Rate() <= 0 {
+			t.Errorf("elapsed %v, rate %v", s.Elapsed, s.Rate())
+		}
+		if len(s.Terms) != 2 {
+			t.Fatalf("expected 2 terms, got %d", len(s.Terms))
+		}
+		if got := s.Terms[0]; got.Name != "a" || got.Found != 2 || got.Remaining != 0 || got.Probability != 1.0/32 {
+			t.Errorf("prefix stats: %+v", got)
+		}
+		if got := s.Terms[1]; got.Label != "digit" || got.Found != 1 || got.Remaining != 0 || got.Probability != 10.0/64 {
+			t.Errorf("regex stats: %+v", got)
+		}
+		if got := s.Terms[0].Likelihood; got <= 0 || got > 1 {
+			t.Errorf("likelihood %v out of range", got)
+		}
+	}
+}
+
+func TestStatsUnknownProbability(t *testing.T) {
+	c := New(Options{Cores: 1}, 0)
+	c.AddMatcher(&endsWithMatcher{suffix: "A=", counter: &AtomicCounter{Value: 1}})
+	c.Find(func(Pair) {})
+
+	s := c.Stats()
+	if got := s.Terms[0]; got.Found != 1 || got.Probability != 0 || got.Likelihood != 0 {
+		t.Errorf("custom matcher stats: %+v", got)
+	}
+}
+
+func TestLikelihood(t *testing.T) {
+	tests := []struct {
+		p    float64
+		n    uint64
+		want float64
+	}{
+		{0, 100, 0},
+		{1, 1, 1},
+		{0.5, 1, 0.5},
+		{0.5, 2, 0.75},
+		{1.0 / 1024, 1024, 1 - math.Pow(1-1.0/1024, 1024)},
+		{1e-30, 1e6, 1e-24},
+	}
+	for _, tt := range tests {
+		got := likelihood(tt.p, tt.n)
+		if math.Abs(got-tt.want) > tt.want*1e-9 {
+			t.Errorf("likelihood(%v, %d) = %v, want %v", tt.p, tt.n, got, tt.want)
+		}
+	}
+}
+
+// --- stream.go ---
+
+func TestStreamWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewStreamWriter(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := Match{Pair: Pair{Private: "priv", Public: "pub", Term: fmt.Sprint(i)}, Time: time.Unix(0, 0).UTC(), Attempts: uint64(i)}
+			if err := w.Write(m); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("expected 10 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var m Match
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		if m.Private != "priv" || m.Public != "pub" || m.Term != fmt.Sprint(m.Attempts) || !m.Time.Equal(time.Unix(0, 0)) {
+			t.Errorf("unexpected match %+v", m)
+		}
+	}
+
+	want := `{"private":"priv","public":"pub","term":"a","time":"1970-01-01T00:00:00Z","attempts":5}` + "\n"
+	buf.Reset()
+	if err := w.Write(Match{Pair: Pair{Private: "priv", Public: "pub", Term: "a"}, Time: time.Unix(0, 0).UTC(), Attempts: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStreamWriterFile(t *testing.T) {
+	path := t.TempDir() + "/results.ndjson"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	w := NewStreamWriter(f)
+	if !w.sync {
+		t.Error("expected a regular file to be synced")
+	}
+	c := New(Options{Cores: 1}, 0)
+	c.AddMatcher(NewPrefixMatcher("a", false, 2))
+	c.Find(func(p Pair) {
+		if err := w.Write(Match{Pair: p, Time: time.Now(), Attempts: c.Stats().Attempts}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var prev uint64
+	for _, line := range lines {
+		var m Match
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		if !keyMatchesPrivate(t, m.Pair) || m.Attempts <= prev {
+			t.Errorf("unexpected match %+v", m)
+		}
+		prev = m.Attempts
+	}
+}
+
+// --- terms.go ---
 
-func TestNewPrivateKey(t *testing.T) {
-	k, err := newPrivateKey()
+func TestParseTerms(t *testing.T) {
+	in := `# team keys
+ab limit=5
+
+VPN cs limit=1 # exact case
+team:limit=5 ci
+  ^x[0-9]y
+A suffix label=office
+`
+	def := SearchTerm{Limit: 2, Mode: MatchPrefix}
+	terms, err := ParseTerms(strings.NewReader(in), def)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	// Clamping per RFC 7748
-	if k[0]&7 != 0 {
-		t.Errorf("low 3 bits of k[0] not cleared: %08b", k[0])
+	want := []SearchTerm{
+		{Term: "ab", Limit: 5, Line: 2},
+		{Term: "VPN", CaseSensitive: true, Limit: 1, Line: 4},
+		{Term: "team", Limit: 5, Line: 5},
+		{Term: "^x[0-9]y", Limit: 2, Line: 6},
+		{Term: "A", Mode: MatchSuffix, Limit: 2, Label: "office", Line: 7},
 	}
-	if k[31]&128 != 0 {
-		t.Errorf("high bit of k[31] not cleared: %08b", k[31])
+	if len(terms) != len(want) {
+		t.Fatalf("expected %d terms, got %+v", len(want), terms)
 	}
-	if k[31]&64 == 0 {
-		t.Errorf("bit 6 of k[31] not set: %08b", k[31])
+	for i := range want {
+		if terms[i] != want[i] {
+			t.Errorf("term %d = %+v, want %+v", i, terms[i], want[i])
+		}
+	}
+
+	m, err := terms[4].Matcher()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := m.(*SuffixMatcher); !ok || labelOf(m) != "office" {
+		t.Errorf("unexpected matcher for %+v", terms[4])
 	}
 }
 
-func TestPrivateKeyString(t *testing.T) {
-	k, err := newPrivateKey()
+func TestParseTerm(t *testing.T) {
+	def := SearchTerm{Limit: 3}
+	tests := []struct {
+		in   string
+		want SearchTerm
+	}{
+		{"ab", SearchTerm{Term: "ab", Limit: 3}},
+		{"VPN:cs:limit=1", SearchTerm{Term: "VPN", CaseSensitive: true, Limit: 1}},
+		{"^a.c:contains:label=x", SearchTerm{Term: "^a.c", Mode: MatchContains, Limit: 3, Label: "x"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTerm(tt.in, def)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTerm(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"ab:", "ab:limit=x", "ab:cs=1", "ab:label="} {
+		if _, err := ParseTerm(in, def); err == nil {
+			t.Errorf("ParseTerm(%q) expected an error", in)
+		}
+	}
+}
+
+func TestFindPerTermSettings(t *testing.T) {
+	c := New(Options{Cores: 2, Incremental: true}, 0)
+	for _, s := range []string{"A:cs:limit=2", "b:limit=3", "Q:cs:suffix"} {
+		term, err := ParseTerm(s, SearchTerm{Limit: 1})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m, err := term.Matcher()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c.AddMatcher(m)
+	}
+
+	found := make(map[string]int)
+	for _, r := range c.CollectToSlice() {
+		found[r.Term]++
+		switch r.Term {
+		case "A":
+			if r.Public[0] != 'A' {
+				t.Errorf("public key %q does not start with A", r.Public)
+			}
+		case "b":
+			if r.Public[0] != 'b' && r.Public[0] != 'B' {
+				t.Errorf("public key %q does not start with b", r.Public)
+			}
+		case "Q":
+			if r.Public[keyChars-1] != 'Q' {
+				t.Errorf("public key %q does not end with Q", r.Public)
+			}
+		}
+	}
+	if found["A"] != 2 || found["b"] != 3 || found["Q"] != 1 {
+		t.Errorf("unexpected matches per term: %v", found)
+	}
+}
+
+func TestParseTermsErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"ab\nab!\n", "line 2: \"ab!\" contains invalid characters"},
+		{"ab limit=0\n", "line 1: invalid limit \"0\""},
+		{"ab lmt=1\n", "line 1: unknown setting \"lmt=1\""},
+		{"ab middle\n", "line 1: unknown setting \"middle\""},
+		{"\n\nab^\n", "line 3: "},
+		{"Z suffix\n", "line 1: \"Z\" will never match"},
+	}
+	for _, tt := range tests {
+		_, err := ParseTerms(strings.NewReader(tt.in), SearchTerm{Limit: 1})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseTerms(%q) = %v, want error containing %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+// --- template.go ---
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.Index}} {{.Term}} {{.Label}} {{.Public}} {{.Private}} {{.Attempts}} {{.Time.Format \"2006-01-02\"}}\n", "")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	s := k.String()
-	decoded, err := base64.StdEncoding.DecodeString(s)
+	if tmpl.PerMatch() {
+		t.Error("expected a single output")
+	}
+
+	found := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, want := range []string{
+		"0 ab  AB/public AB/private 100 2024-05-01\n",
+		"1 ab  AB/public AB/private 100 2024-05-01\n",
+	} {
+		m := Match{Pair: Pair{Private: "AB/private", Public: "AB/public", Term: "ab"}, Time: found, Attempts: 100}
+		name, got, err := tmpl.Render(m)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "" || string(got) != want {
+			t.Errorf("match %d rendered as %q, %q, want %q", i, name, got, want)
+		}
+	}
+}
+
+func TestTemplatePerMatch(t *testing.T) {
+	tmpl, err := ParseTemplate("{{.Private}}\n", " keys/{{.Label}}-{{.Index}}.key\n")
 	if err != nil {
-		t.Fatalf("private key string is not valid base64: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(decoded) != KeySize {
-		t.Errorf("expected %d bytes, got %d", KeySize, len(decoded))
+	if !tmpl.PerMatch() {
+		t.Error("expected a file per match")
+	}
+
+	name, got, err := tmpl.Render(Match{Pair: Pair{Private: "private", Public: "public", Label: "alice"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "keys/alice-0.key" || string(got) != "private\n" {
+		t.Errorf("unexpected rendering: %q, %q", name, got)
+	}
+
+	tmpl, err = ParseTemplate("{{.Private}}", "{{if .Label}}{{.Label}}{{end}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := tmpl.Render(Match{Pair: Pair{Private: "private", Public: "public"}}); err == nil {
+		t.Error("expected an error for an empty file name")
 	}
 }
 
-func TestPublicKeyString(t *testing.T) {
+func TestParseTemplateErrors(t *testing.T) {
+	tests := []struct {
+		text, name string
+	}{
+		{"{{.Private", ""},
+		{"{{.Unknown}}", ""},
+		{"{{.Public}}", "{{.Unknown}}.key"},
+		{"{{.Public}}", "{{end}}"},
+		{"{{.Public}}", " "},
+	}
+	for _, tt := range tests {
+		if _, err := ParseTemplate(tt.text, tt.name); err == nil {
+			t.Errorf("ParseTemplate(%q, %q) expected an error", tt.text, tt.name)
+		}
+	}
+
+	// templates are checked with full-length keys
+	if _, err := ParseTemplate("{{slice .Public 0 8}}", ""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// --- trie.go ---
+
+func TestNeverMatchingSearch(t *testing.T) {
+	tests := []Matcher{
+		NewPrefixMatcher("ab=", false, 1),
+		NewSuffixMatcher("z", true, 1),
+		NewContainsMatcher("ab=", false, 1),
+		NewRegexpMatcher(regexp.MustCompile(`^=`), 1),
+	}
+	for _, m := range tests {
+		if p, ok := m.(interface{ Probability() float64 }); ok && p.Probability() != 0 {
+			t.Errorf("%T %s: expected a probability of 0, got %g", m, m.Name(), p.Probability())
+		}
+		c := New(Options{Cores: 1}, 0)
+		c.AddMatcher(m)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := c.FindContext(ctx, func(Pair) {})
+		cancel()
+
+		var findErr *FindError
+		if !errors.As(err, &findErr) || findErr.Status != TimedOut {
+			t.Errorf("%T %s: expected a timeout, got %v", m, m.Name(), err)
+		}
+		if s := c.Stats(); s.Terms[0].Remaining != 1 {
+			t.Errorf("%T %s: unexpected stats %+v", m, m.Name(), s.Terms[0])
+		}
+	}
+}
+
+func TestPrefixIndex(t *testing.T) {
+	raw, err := base64.StdEncoding.DecodeString("AbCdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQ=")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := Key(raw)
+
+	// the first set is compared linearly, the second uses the trie
+	for _, extra := range []int{0, linearPrefixLimit} {
+		var idx prefixIndex
+		ab := NewPrefixMatcher("ab", false, 1)
+		abc := NewPrefixMatcher("AbC", true, 1)
+		a := NewPrefixMatcher("a", false, 1)
+		xyz := NewPrefixMatcher("xyz", false, 1)
+		for _, m := range []*PrefixMatcher{ab, abc, a, xyz} {
+			idx.add(m)
+		}
+		for i := 0; i < extra; i++ {
+			idx.add(NewPrefixMatcher("zz"+string(base64Alphabet[i]), true, 1))
+		}
+		if idx.active.Load() != int64(4+extra) {
+			t.Fatalf("expected %d active prefixes, got %d", 4+extra, idx.active.Load())
+		}
+
+		var hits []string
+		idx.match(&key, func(m *PrefixMatcher) {
+			hits = append(hits, m.Name())
+		})
+		sort.Strings(hits)
+		if strings.Join(hits, ",") != "AbC,a,ab" {
+			t.Errorf("unexpected hits with %d prefixes: %v", 4+extra, hits)
+		}
+
+		if !idx.dec(a) || idx.dec(a) {
+			t.Error("expected exactly one successful dec for a limit of 1")
+		}
+		if idx.active.Load() != int64(3+extra) || idx.completed() {
+			t.Errorf("expected %d active prefixes, got %d", 3+extra, idx.active.Load())
+		}
+	}
+}
+
+func TestCrunchManyPrefixes(t *testing.T) {
+	opts := Options{Cores: 2, Incremental: true}
+	c := New(opts, 0)
+
+	// all two character prefixes beginning with 'a', each tracked separately
+	var matchers []*PrefixMatcher
+	for _, ch := range "abcdefghijklmnopqrstuvwxyz0123456789+/" {
+		m := NewPrefixMatcher("a"+string(ch), false, 1)
+		matchers = append(matchers, m)
+		c.AddMatcher(m)
+	}
+
+	results := c.CollectToSlice()
+	if len(results) != len(matchers) {
+		t.Fatalf("expected %d results, got %d", len(matchers), len(results))
+	}
+	for _, m := range matchers {
+		if m.Counter().Get() > 0 {
+			t.Errorf("prefix %q was not satisfied", m.Name())
+		}
+	}
+}
+
+func TestKeySextet(t *testing.T) {
 	k, err := newPrivateKey()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	pub := k.Public()
-	s := pub.String()
-	decoded, err := base64.StdEncoding.DecodeString(s)
-	if err != nil {
-		t.Fatalf("public key string is not valid base64: %v", err)
+	encoded := pub.String()
+	for i := 0; i < 43; i++ {
+		if got := base64Alphabet[pub.sextet(i)]; got != encoded[i] {
+			t.Errorf("sextet(%d) = %q, want %q", i, got, encoded[i])
+		}
 	}
-	if len(decoded) != KeySize {
-		t.Errorf("expected %d bytes, got %d", KeySize, len(decoded))
+}
+
+// --- config.go ---
+
+func TestWGQuickConfig(t *testing.T) {
+	p := Pair{Private: "cHJpdmF0ZQ==", Public: "cHVibGljIGtleQ=="}
+	cfg := InterfaceConfig{Address: []string{"10.0.0.2/24", "fd00::2/64"}, ListenPort: 51820, DNS: []string{"10.0.0.1", "vpn.example.com"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `[Interface]
+# PublicKey = cHVibGljIGtleQ==
+PrivateKey = cHJpdmF0ZQ==
+Address = 10.0.0.2/24, fd00::2/64
+ListenPort = 51820
+DNS = 10.0.0.1, vpn.example.com
+`
+	if got := p.WGQuickConfig(cfg); got != want {
+		t.Errorf("unexpected wg-quick configuration:\n%s\nwant:\n%s", got, want)
+	}
+
+	p.Label = "alice"
+	want = `[Peer]
+# alice
+PublicKey = cHVibGljIGtleQ==
+AllowedIPs = 10.0.0.2/32, fd00::2/128
+`
+	if got := p.PeerConfig(cfg); got != want {
+		t.Errorf("unexpected peer configuration:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := p.WGQuickConfig(InterfaceConfig{}); strings.Contains(got, "Address") || strings.Contains(got, "DNS") {
+		t.Errorf("unexpected optional settings in configuration:\n%s", got)
 	}
 }
 
-func TestPublicKeyDeterministic(t *testing.T) {
-	k, err := newPrivateKey()
+func TestInterfaceConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg   InterfaceConfig
+		valid bool
+	}{
+		{InterfaceConfig{}, true},
+		{InterfaceConfig{Address: []string{"10.0.0.2"}}, true},
+		{InterfaceConfig{Address: []string{"10.0.0.300/24"}}, false},
+		{InterfaceConfig{Address: []string{"10.0.0.2/33"}}, false},
+		{InterfaceConfig{DNS: []string{"1.1.1.1", "example.com"}}, true},
+		{InterfaceConfig{DNS: []string{"not a server"}}, false},
+		{InterfaceConfig{ListenPort: 70000}, false},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.cfg, err, tt.valid)
+		}
+	}
+}
+
+func TestParsePeer(t *testing.T) {
+	key := "cHVibGljIGtleSBvZiB0aGUgc2VydmVyISEhISEhISE="
+	p, err := ParsePeer(key + ", vpn.example.com:51820, 10.0.0.0/24, fd00::/64")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	pub1 := k.Public()
-	pub2 := k.Public()
-	if pub1 != pub2 {
-		t.Error("Public() is not deterministic for the same private key")
+	if p.PublicKey != key || p.Endpoint != "vpn.example.com:51820" || strings.Join(p.AllowedIPs, " ") != "10.0.0.0/24 fd00::/64" {
+		t.Errorf("unexpected peer: %+v", p)
+	}
+	if p, err := ParsePeer(key); err != nil || p.Endpoint != "" || p.AllowedIPs != nil {
+		t.Errorf("unexpected peer without endpoint: %+v, %v", p, err)
+	}
+	if p, err := ParsePeer(key + ",,10.0.0.1"); err != nil || p.Endpoint != "" || len(p.AllowedIPs) != 1 {
+		t.Errorf("unexpected peer without endpoint: %+v, %v", p, err)
+	}
+
+	for _, in := range []string{"", "not a key", "cHVibGlj,vpn.example.com:51820", key + ",vpn.example.com", key + ",[::1]:51820,10.0.0.0/33"} {
+		if _, err := ParsePeer(in); err == nil {
+			t.Errorf("ParsePeer(%q) expected an error", in)
+		}
 	}
 }
 
-func TestDistinctKeysGenerated(t *testing.T) {
-	k1, _ := newPrivateKey()
-	k2, _ := newPrivateKey()
-	if k1 == k2 {
-		t.Error("two generated private keys should not be identical")
+func TestWGQuickConfigPeers(t *testing.T) {
+	p := Pair{Private: "cHJpdmF0ZQ==", Public: "cHVibGljIGtleQ=="}
+	cfg := InterfaceConfig{Peers: []Peer{
+		{PublicKey: "c2VydmVy", Endpoint: "vpn.example.com:51820", AllowedIPs: []string{"10.0.0.0/24", "fd00::/64"}},
+		{PublicKey: "b3RoZXI="},
+	}}
+	want := `[Interface]
+# PublicKey = cHVibGljIGtleQ==
+PrivateKey = cHJpdmF0ZQ==
+
+[Peer]
+PublicKey = c2VydmVy
+Endpoint = vpn.example.com:51820
+AllowedIPs = 10.0.0.0/24, fd00::/64
+
+[Peer]
+PublicKey = b3RoZXI=
+`
+	if got := p.WGQuickConfig(cfg); got != want {
+		t.Errorf("unexpected wg-quick configuration:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// --- networkd.go ---
+
+func TestNetworkdConfig(t *testing.T) {
+	p := Pair{Private: "cHJpdmF0ZQ==", Public: "cHVibGljIGtleQ==", Label: "alice"}
+	cfg := InterfaceConfig{
+		Address:    []string{"10.0.0.2/24", "fd00::2/64"},
+		ListenPort: 51820,
+		DNS:        []string{"10.0.0.1", "vpn.example.com", "corp.example.com"},
+		Peers:      []Peer{{PublicKey: "c2VydmVy", Endpoint: "vpn.example.com:51820", AllowedIPs: []string{"10.0.0.0/24", "fd00::/64"}}},
+	}
+
+	want := `[NetDev]
+Name=wg0
+Kind=wireguard
+Description=alice
+
+[WireGuard]
+# PublicKey=cHVibGljIGtleQ==
+PrivateKeyFile=/etc/systemd/network/wg0.key
+ListenPort=51820
+
+[WireGuardPeer]
+PublicKey=c2VydmVy
+Endpoint=vpn.example.com:51820
+AllowedIPs=10.0.0.0/24,fd00::/64
+`
+	if got := p.NetdevConfig("wg0", "/etc/systemd/network/wg0.key", cfg); got != want {
+		t.Errorf("unexpected .netdev file:\n%s\nwant:\n%s", got, want)
+	}
+
+	want = `[Match]
+Name=wg0
+
+[Network]
+Address=10.0.0.2/24
+Address=fd00::2/64
+DNS=10.0.0.1
+Domains=vpn.example.com corp.example.com
+`
+	if got := p.NetworkConfig("wg0", cfg); got != want {
+		t.Errorf("unexpected .network file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// --- networkmanager.go ---
+
+func TestNMConnection(t *testing.T) {
+	p := Pair{Private: "cHJpdmF0ZQ==", Public: "cHVibGljIGtleQ==", Label: "alice"}
+	cfg := InterfaceConfig{
+		Address:    []string{"10.0.0.2/24", "10.0.1.2"},
+		ListenPort: 51820,
+		DNS:        []string{"10.0.0.1", "fd00::1", "vpn.example.com"},
+		Peers:      []Peer{{PublicKey: "c2VydmVy", Endpoint: "vpn.example.com:51820", AllowedIPs: []string{"10.0.0.0/24"}}},
+	}
+
+	want := `[connection]
+id=alice
+uuid=` + connectionUUID(p.Public) + `
+type=wireguard
+interface-name=wg0
+
+[wireguard]
+# public-key=cHVibGljIGtleQ==
+private-key=cHJpdmF0ZQ==
+listen-port=51820
+
+[wireguard-peer.c2VydmVy]
+endpoint=vpn.example.com:51820
+allowed-ips=10.0.0.0/24;
+
+[ipv4]
+address1=10.0.0.2/24
+address2=10.0.1.2/32
+dns=10.0.0.1;
+dns-search=vpn.example.com;
+method=manual
+
+[ipv6]
+method=disabled
+`
+	if got := p.NMConnection("wg0", cfg); got != want {
+		t.Errorf("unexpected .nmconnection file:\n%s\nwant:\n%s", got, want)
+	}
+
+	uuid := connectionUUID(p.Public)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-8[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(uuid) {
+		t.Errorf("invalid UUID %q", uuid)
+	}
+	if uuid == connectionUUID("b3RoZXI=") {
+		t.Error("expected a different UUID for a different key")
 	}
 }
 
@@ -97,12 +2026,13 @@ func TestCrunchWordMatch(t *testing.T) {
 
 	var matched []Pair
 	buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
+	r := InsecureSeededSource{Seed: 1}.Reader(0)
 
 	// Run crunch until we get one match for a short common prefix.
 	// Base64 chars are a-z, A-Z, 0-9, +, / — single char prefix has 1/64 chance.
-	c.WordMap["a"] = &AtomicCounter{Value: 1}
+	c.AddMatcher(NewPrefixMatcher("a", opts.CaseSensitive, 1))
 	for len(matched) == 0 {
-		c.crunch(func(p Pair) { matched = append(matched, p) }, buf)
+		c.crunch(r, func(p Pair) { matched = append(matched, p) }, buf)
 	}
 
 	if len(matched) != 1 {
@@ -119,10 +2049,11 @@ func TestCrunchCaseSensitive(t *testing.T) {
 
 	var matched []Pair
 	buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
+	r := InsecureSeededSource{Seed: 1}.Reader(0)
 
-	c.WordMap["A"] = &AtomicCounter{Value: 1}
+	c.AddMatcher(NewPrefixMatcher("A", opts.CaseSensitive, 1))
 	for len(matched) == 0 {
-		c.crunch(func(p Pair) { matched = append(matched, p) }, buf)
+		c.crunch(r, func(p Pair) { matched = append(matched, p) }, buf)
 	}
 
 	if !strings.HasPrefix(matched[0].Public, "A") {
@@ -136,11 +2067,12 @@ func TestCrunchRegexpMatch(t *testing.T) {
 
 	var matched []Pair
 	buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
+	r := InsecureSeededSource{Seed: 1}.Reader(0)
 
 	re := regexp.MustCompile(`(?i)^[ab]`)
-	c.RegexpMap[re] = &AtomicCounter{Value: 1}
+	c.AddMatcher(NewRegexpMatcher(re, 1))
 	for len(matched) == 0 {
-		c.crunch(func(p Pair) { matched = append(matched, p) }, buf)
+		c.crunch(r, func(p Pair) { matched = append(matched, p) }, buf)
 	}
 
 	pub := strings.ToLower(matched[0].Public)
@@ -154,12 +2086,13 @@ func TestCrunchCounterExhausted(t *testing.T) {
 	c := New(opts, 0)
 
 	buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
-	c.WordMap["a"] = &AtomicCounter{Value: 0} // already exhausted
+	r := InsecureSeededSource{Seed: 1}.Reader(0)
+	c.AddMatcher(NewPrefixMatcher("a", opts.CaseSensitive, 0)) // already exhausted
 
 	var called int
 	// Run a few iterations; counter is 0 so completed=true on first call
 	for i := 0; i < 5; i++ {
-		c.crunch(func(Pair) { called++ }, buf)
+		c.crunch(r, func(Pair) { called++ }, buf)
 	}
 	if called != 0 {
 		t.Errorf("expected no matches when counter exhausted, got %d", called)
@@ -169,7 +2102,7 @@ func TestCrunchCounterExhausted(t *testing.T) {
 func TestFindWordMatch(t *testing.T) {
 	opts := Options{Cores: 2, CaseSensitive: false}
 	c := New(opts, 0)
-	c.WordMap["a"] = &AtomicCounter{Value: 1}
+	c.AddMatcher(NewPrefixMatcher("a", opts.CaseSensitive, 1))
 
 	var results []Pair
 	c.Find(func(p Pair) { results = append(results, p) })
@@ -185,7 +2118,7 @@ func TestFindWordMatch(t *testing.T) {
 func TestCollectToSlice(t *testing.T) {
 	opts := Options{Cores: 2, CaseSensitive: false}
 	c := New(opts, 0)
-	c.WordMap["a"] = &AtomicCounter{Value: 2}
+	c.AddMatcher(NewPrefixMatcher("a", opts.CaseSensitive, 2))
 
 	results := c.CollectToSlice()
 	if len(results) != 2 {
@@ -202,7 +2135,7 @@ func TestFindTimeout(t *testing.T) {
 	opts := Options{Cores: 1, CaseSensitive: false}
 	// Use a prefix that will never match
 	c := New(opts, 100*time.Millisecond)
-	c.WordMap["aaaaaaaaaa"] = &AtomicCounter{Value: 1}
+	c.AddMatcher(NewPrefixMatcher("aaaaaaaaaa", opts.CaseSensitive, 1))
 
 	var results []Pair
 	c.Find(func(p Pair) { results = append(results, p) })
@@ -213,6 +2146,63 @@ func TestFindTimeout(t *testing.T) {
 	}
 }
 
+func TestFindContextFinished(t *testing.T) {
+	c := New(Options{Cores: 2}, 0)
+	c.AddMatcher(NewPrefixMatcher("a", false, 1))
+
+	if err := c.FindContext(context.Background(), func(Pair) {}); err != nil {
+		t.Errorf("expected nil error once all searches are satisfied, got %v", err)
+	}
+}
+
+func TestFindContextDeadline(t *testing.T) {
+	c := New(Options{Cores: 1}, 0)
+	c.AddMatcher(NewPrefixMatcher("aaaaaaaaaa", false, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := c.FindContext(ctx, func(Pair) {})
+
+	var findErr *FindError
+	if !errors.As(err, &findErr) || findErr.Status != TimedOut {
+		t.Fatalf("expected a timed out FindError, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error to wrap context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestFindContextCancelled(t *testing.T) {
+	c := New(Options{Cores: 2}, 0)
+	c.AddMatcher(NewPrefixMatcher("aaaaaaaaaa", false, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	results, err := c.CollectToSliceContext(ctx)
+
+	var findErr *FindError
+	if !errors.As(err, &findErr) || findErr.Status != Cancelled {
+		t.Fatalf("expected a cancelled FindError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error to wrap context.Canceled, got %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %d", len(results))
+	}
+}
+
+func TestFindContextAbort(t *testing.T) {
+	c := New(Options{Cores: 1}, 0)
+	c.AddMatcher(NewPrefixMatcher("aaaaaaaaaa", false, 1))
+	c.Abort.Store(true)
+
+	var findErr *FindError
+	if err := c.FindContext(context.Background(), func(Pair) {}); !errors.As(err, &findErr) || findErr.Status != Cancelled {
+		t.Errorf("expected a cancelled FindError, got %v", err)
+	}
+}
+
 // --- utils.go ---
 
 func TestIsValidSearch(t *testing.T) {
@@ -318,6 +2308,8 @@ func TestHumanizeDuration(t *testing.T) {
 		{2 * time.Minute, "2 minutes"},
 		{2 * time.Hour, "2 hours, 0 minutes"},
 		{48 * time.Hour, "2 days, 0 hours"},
+		{3 * 365 * 24 * time.Hour, "3 years"},
+		{math.MaxInt64, "292 years"},
 	}
 	for _, tt := range tests {
 		got := HumanizeDuration(tt.d)
@@ -327,18 +2319,136 @@ func TestHumanizeDuration(t *testing.T) {
 	}
 }
 
+func TestHumanizeSeconds(t *testing.T) {
+	const year = 8760 * 3600
+	tests := []struct {
+		s    float64
+		want string
+	}{
+		{-1, "0 seconds"},
+		{30, "30 seconds"},
+		{1500 * year, "1,500 years"},
+		{3.2e9 * year, "3.2e9 years"},
+		{4.6e77 * year, "4.6e77 years"},
+		{math.Inf(1), "forever"},
+	}
+	for _, tt := range tests {
+		got := HumanizeSeconds(tt.s)
+		if got != tt.want {
+			t.Errorf("HumanizeSeconds(%v) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestFloatFormat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{0, "0"},
+		{1024, "1,024"},
+		{999999999999999, "999,999,999,999,999"},
+		{1e15, "1.0e15"},
+		{math.Pow(64, 43), "4.6e77"},
+		{1e-5, "0"},
+	}
+	for _, tt := range tests {
+		got := FloatFormat(tt.f)
+		if got != tt.want {
+			t.Errorf("FloatFormat(%v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+	if got := ScientificFormat(2.5e-7); got != "2.5e-7" {
+		t.Errorf("ScientificFormat(2.5e-7) = %q", got)
+	}
+}
+
 func TestCalculateProbability(t *testing.T) {
 	// Case-insensitive: alpha chars have higher probability (lower denominator)
-	pInsensitive := CalculateProbability("a", false)
-	pSensitive := CalculateProbability("a", true)
+	pInsensitive := CalculateProbability("a", false, MatchPrefix)
+	pSensitive := CalculateProbability("a", true, MatchPrefix)
 	if pInsensitive >= pSensitive {
-		t.Errorf("case-insensitive probability (%d) should be lower than case-sensitive (%d)", pInsensitive, pSensitive)
+		t.Errorf("case-insensitive probability (%v) should be lower than case-sensitive (%v)", pInsensitive, pSensitive)
 	}
 
 	// Longer prefix should have higher probability value (lower chance = higher number)
-	p1 := CalculateProbability("a", false)
-	p2 := CalculateProbability("ab", false)
+	p1 := CalculateProbability("a", false, MatchPrefix)
+	p2 := CalculateProbability("ab", false, MatchPrefix)
 	if p2 <= p1 {
-		t.Errorf("two-char probability (%d) should exceed one-char (%d)", p2, p1)
+		t.Errorf("two-char probability (%v) should exceed one-char (%v)", p2, p1)
+	}
+}
+
+func TestCalculateProbabilityModes(t *testing.T) {
+	tests := []struct {
+		s             string
+		caseSensitive bool
+		mode          MatchMode
+		want          float64
+	}{
+		{"a", false, MatchPrefix, 32},
+		{"a", true, MatchPrefix, 64},
+		{"ab1", false, MatchPrefix, 65536},
+		// the last character is one of 16
+		{"A", true, MatchSuffix, 16},
+		{"a", true, MatchSuffix, 0},
+		// only the uppercase A is possible at the end of a key
+		{"a", false, MatchSuffix, 16},
+		// the character before the last is one of 32
+		{"xA", true, MatchSuffix, 512},
+		{"IA", true, MatchSuffix, 0},
+		{"zzA", true, MatchSuffix, 32768},
+		// 40 positions, as the lowercase letters cannot appear 2nd or 3rd to last
+		{"ab", true, MatchContains, 103},
+	}
+	for _, tt := range tests {
+		got := CalculateProbability(tt.s, tt.caseSensitive, tt.mode)
+		if got != tt.want {
+			t.Errorf("CalculateProbability(%q, %v, %s) = %v, want %v", tt.s, tt.caseSensitive, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateProbabilityLong(t *testing.T) {
+	// 11 case-sensitive characters used to overflow an int64
+	want := math.Pow(64, 11)
+	if got := CalculateProbability("abcdefghijk", true, MatchPrefix); got != want {
+		t.Errorf("CalculateProbability(11 chars) = %v, want %v", got, want)
+	}
+
+	// a full-length key: 41 characters of 64, then 32 and 16
+	term := strings.Repeat("a", 41) + "AA"
+	want = math.Pow(64, 41) * 32 * 16
+	if got := CalculateProbability(term, true, MatchPrefix); math.Abs(got-want)/want > 1e-9 {
+		t.Errorf("CalculateProbability(43 chars) = %v, want %v", got, want)
+	}
+
+	seconds := EstimateSeconds(CalculateProbability("abcdefghijklmn", true, MatchPrefix), time.Microsecond)
+	if got := HumanizeSeconds(seconds); got != "6.1e11 years" {
+		t.Errorf("estimate for 14 chars = %q, want %q", got, "6.1e11 years")
+	}
+}
+
+func TestIsValidLiteral(t *testing.T) {
+	tests := []struct {
+		s             string
+		caseSensitive bool
+		mode          MatchMode
+		valid         bool
+	}{
+		{"abc", false, MatchPrefix, true},
+		{"ab!", false, MatchPrefix, false},
+		{"abc", false, MatchSuffix, true},
+		{"xyc", true, MatchSuffix, true},
+		{"abc", true, MatchSuffix, false},
+		{"xyC", true, MatchSuffix, false},
+		{"abI", true, MatchContains, true},
+		{strings.Repeat("a", 44), false, MatchContains, false},
+	}
+	for _, tt := range tests {
+		got := IsValidLiteral(tt.s, tt.caseSensitive, tt.mode) == ""
+		if got != tt.valid {
+			t.Errorf("IsValidLiteral(%q, %v, %s) valid = %v, want %v", tt.s, tt.caseSensitive, tt.mode, got, tt.valid)
+		}
 	}
 }
@@ -0,0 +1,454 @@
+package keygen
+
+import (
+	"fmt"
+	"math/bits"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Matcher is a search term that generated public keys are compared against.
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Matcher interface {
+	// Match reports whether the base64-encoded public key satisfies the search
+	Match(key string) bool
+	// Name returns a human-readable name for the search, such as the search term
+	Name() string
+	// Counter returns the number of matching keys still required
+	Counter() *AtomicCounter
+}
+
+// Labeler is implemented by matchers carrying a label, such as the name of the
+// peer a search is for. The label is reported with every match.
+type Labeler interface {
+	Label() string
+}
+
+// SetLabel sets the label reported with matches of m, if m supports labels
+func SetLabel(m Matcher, label string) {
+	if l, ok := m.(interface{ SetLabel(string) }); ok {
+		l.SetLabel(label)
+	}
+}
+
+// labelOf returns the label of m, if any
+func labelOf(m Matcher) string {
+	if l, ok := m.(Labeler); ok {
+		return l.Label()
+	}
+	return ""
+}
+
+// Estimator is implemented by matchers which know how likely a random key is to
+// match, used to report progress
+type Estimator interface {
+	// Probability returns the probability of a single random key matching
+	Probability() float64
+}
+
+// KeyMatcher is implemented by matchers which can compare the raw bytes of a
+// public key, avoiding the cost of base64-encoding every candidate.
+type KeyMatcher interface {
+	Matcher
+	// MatchKey reports whether the public key satisfies the search
+	MatchKey(key *Key) bool
+}
+
+// keyChars is the number of base64 characters derived from a key,
+// the 44th character is always the = padding
+const keyChars = 43
+
+// penultimateSextets is the set of 6-bit values the 42nd character of a key can take,
+// as it includes the always-zero highest bit of the key
+const penultimateSextets uint64 = 0x00ff00ff00ff00ff
+
+// finalSextets is the set of 6-bit values the 43rd character of a key can take,
+// as its two lowest bits are padding
+const finalSextets uint64 = 0x1111111111111111
+
+// MatchMode is where in the public key a literal search term must appear
+type MatchMode int
+
+const (
+	// MatchPrefix matches search terms at the start of the key
+	MatchPrefix MatchMode = iota
+	// MatchSuffix matches search terms at the end of the key, before the = padding
+	MatchSuffix
+	// MatchContains matches search terms anywhere in the key
+	MatchContains
+)
+
+// String returns the match mode as a string
+func (m MatchMode) String() string {
+	switch m {
+	case MatchSuffix:
+		return "suffix"
+	case MatchContains:
+		return "contains"
+	default:
+		return "prefix"
+	}
+}
+
+// ParseMatchMode parses a match mode from its string form, as returned by String.
+// An empty string is MatchPrefix.
+func ParseMatchMode(s string) (MatchMode, error) {
+	switch s {
+	case "", "prefix":
+		return MatchPrefix, nil
+	case "suffix":
+		return MatchSuffix, nil
+	case "contains":
+		return MatchContains, nil
+	}
+	return MatchPrefix, fmt.Errorf("invalid match mode %q", s)
+}
+
+// positionSextets returns the set of 6-bit values the i'th character of a key can take
+func positionSextets(i int) uint64 {
+	switch i {
+	case keyChars - 2:
+		return penultimateSextets
+	case keyChars - 1:
+		return finalSextets
+	}
+	return ^uint64(0)
+}
+
+// charSets returns the set of acceptable 6-bit values for each character of a
+// literal search term, or false if it contains characters which are not valid
+// in a key or is longer than a key
+func charSets(term string, caseSensitive bool) ([]uint64, bool) {
+	if len(term) > keyChars {
+		return nil, false
+	}
+
+	sets := make([]uint64, len(term))
+	for i := 0; i < len(term); i++ {
+		v := base64Index[term[i]]
+		if v > 63 {
+			return nil, false
+		}
+		sets[i] = 1 << v
+		if !caseSensitive && v < 52 {
+			// letter, also accept the other case
+			sets[i] |= 1 << ((v + 26) % 52)
+		}
+	}
+
+	return sets, true
+}
+
+// windowProbability returns the probability that a random key contains
+// the characters of sets starting at character start. The smallest possible
+// probability, for a term spanning a whole key, is 2^-255, well within the
+// range of a float64.
+func windowProbability(sets []uint64, start int) float64 {
+	p := 1.0
+	for i, set := range sets {
+		pos := positionSextets(start + i)
+		p *= float64(bits.OnesCount64(set&pos)) / float64(bits.OnesCount64(pos))
+	}
+	return p
+}
+
+// NewMatcher returns a Matcher for the literal search term in the given mode,
+// stopping after limit matches
+func NewMatcher(term string, caseSensitive bool, mode MatchMode, limit int64) Matcher {
+	switch mode {
+	case MatchSuffix:
+		return NewSuffixMatcher(term, caseSensitive, limit)
+	case MatchContains:
+		return NewContainsMatcher(term, caseSensitive, limit)
+	default:
+		return NewPrefixMatcher(term, caseSensitive, limit)
+	}
+}
+
+// PrefixMatcher matches public keys starting with a literal search term
+type PrefixMatcher struct {
+	literal
+	mask  []byte // case-sensitive: bits of the key fixed by the prefix
+	value []byte // case-sensitive: expected value of the masked bits
+}
+
+// NewPrefixMatcher returns a Matcher for public keys starting with prefix,
+// stopping after limit matches
+func NewPrefixMatcher(prefix string, caseSensitive bool, limit int64) *PrefixMatcher {
+	m := &PrefixMatcher{literal: newLiteral(prefix, caseSensitive, limit)}
+	m.compile()
+
+	return m
+}
+
+// compile converts the prefix to bitmask/value pairs over the raw key bytes
+// for case-sensitive matches. Case-insensitive matches use the sets of
+// acceptable 6-bit values per character.
+func (m *PrefixMatcher) compile() {
+	n := (len(m.allowed)*6 + 7) / 8
+	m.mask = make([]byte, min(n, KeySize))
+	m.value = make([]byte, min(n, KeySize))
+
+	for i := range m.allowed {
+		m.allowed[i] &= positionSextets(i)
+		if m.allowed[i] == 0 {
+			m.allowed = nil
+			return
+		}
+
+		v := base64Index[m.term[i]]
+		for j := 0; j < 6 && i*6+j < KeySize*8; j++ {
+			bit := i*6 + j
+			m.mask[bit/8] |= 1 << (7 - bit%8)
+			if v>>(5-j)&1 == 1 {
+				m.value[bit/8] |= 1 << (7 - bit%8)
+			}
+		}
+	}
+}
+
+// Probability returns the probability of a random key starting with the prefix
+func (m *PrefixMatcher) Probability() float64 {
+	return literalProbability(m.term, m.caseSensitive, MatchPrefix)
+}
+
+// Match reports whether key starts with the prefix
+func (m *PrefixMatcher) Match(key string) bool {
+	return m.allowed != nil && m.matchStringAt(key, 0)
+}
+
+// MatchKey reports whether the base64 encoding of key starts with the prefix
+func (m *PrefixMatcher) MatchKey(key *Key) bool {
+	if m.allowed == nil {
+		return false
+	}
+	if m.caseSensitive {
+		for i, mask := range m.mask {
+			if key[i]&mask != m.value[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	return m.matchAt(key, 0)
+}
+
+// literal holds the compiled sets of a literal search term
+type literal struct {
+	term          string
+	caseSensitive bool
+	counter       *AtomicCounter
+	allowed       []uint64 // set of acceptable 6-bit values per character, nil if the term can never match
+	label         string
+}
+
+// newLiteral compiles a literal search term
+func newLiteral(term string, caseSensitive bool, limit int64) literal {
+	if !caseSensitive {
+		term = strings.ToLower(term)
+	}
+	l := literal{
+		term:          term,
+		caseSensitive: caseSensitive,
+		counter:       &AtomicCounter{Value: limit},
+	}
+	if sets, ok := charSets(term, caseSensitive); ok {
+		l.allowed = sets
+	}
+
+	return l
+}
+
+// matchAt reports whether key contains the term starting at character start
+func (l *literal) matchAt(key *Key, start int) bool {
+	for i, set := range l.allowed {
+		if set>>key.sextet(start+i)&1 == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// matchStringAt reports whether the base64-encoded key contains the term starting at character start
+func (l *literal) matchStringAt(key string, start int) bool {
+	if start < 0 || start+len(l.term) > len(key) {
+		return false
+	}
+	for i := 0; i < len(l.term); i++ {
+		b := key[start+i]
+		if !l.caseSensitive && b >= 'A' && b <= 'Z' {
+			b += 32
+		}
+		if b != l.term[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Name returns the search term
+func (l *literal) Name() string {
+	return l.term
+}
+
+// Counter returns the number of matching keys still required
+func (l *literal) Counter() *AtomicCounter {
+	return l.counter
+}
+
+// Label returns the label reported with matches
+func (l *literal) Label() string {
+	return l.label
+}
+
+// SetLabel sets the label reported with matches
+func (l *literal) SetLabel(label string) {
+	l.label = label
+}
+
+// SuffixMatcher matches public keys ending with a literal search term,
+// ignoring the = padding
+type SuffixMatcher struct {
+	literal
+}
+
+// NewSuffixMatcher returns a Matcher for public keys ending with suffix,
+// stopping after limit matches
+func NewSuffixMatcher(suffix string, caseSensitive bool, limit int64) *SuffixMatcher {
+	m := &SuffixMatcher{literal: newLiteral(suffix, caseSensitive, limit)}
+	start := keyChars - len(m.allowed)
+	for i := range m.allowed {
+		// the final characters of a key are restricted
+		m.allowed[i] &= positionSextets(start + i)
+		if m.allowed[i] == 0 {
+			m.allowed = nil
+			break
+		}
+	}
+
+	return m
+}
+
+// Probability returns the probability of a random key ending with the suffix
+func (m *SuffixMatcher) Probability() float64 {
+	return literalProbability(m.term, m.caseSensitive, MatchSuffix)
+}
+
+// Match reports whether key ends with the suffix
+func (m *SuffixMatcher) Match(key string) bool {
+	return m.allowed != nil && m.matchStringAt(key, keyChars-len(m.term))
+}
+
+// MatchKey reports whether the base64 encoding of key ends with the suffix
+func (m *SuffixMatcher) MatchKey(key *Key) bool {
+	return m.allowed != nil && m.matchAt(key, keyChars-len(m.allowed))
+}
+
+// ContainsMatcher matches public keys containing a literal search term anywhere
+type ContainsMatcher struct {
+	literal
+}
+
+// NewContainsMatcher returns a Matcher for public keys containing term,
+// stopping after limit matches
+func NewContainsMatcher(term string, caseSensitive bool, limit int64) *ContainsMatcher {
+	return &ContainsMatcher{literal: newLiteral(term, caseSensitive, limit)}
+}
+
+// Probability returns the probability of a random key containing the term
+func (m *ContainsMatcher) Probability() float64 {
+	return literalProbability(m.term, m.caseSensitive, MatchContains)
+}
+
+// Match reports whether key contains the term
+func (m *ContainsMatcher) Match(key string) bool {
+	if m.allowed == nil {
+		return false
+	}
+	for start := 0; start+len(m.term) <= keyChars; start++ {
+		if m.matchStringAt(key, start) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchKey reports whether the base64 encoding of key contains the term
+func (m *ContainsMatcher) MatchKey(key *Key) bool {
+	if m.allowed == nil {
+		return false
+	}
+	var sextets [keyChars]byte
+	for i := range sextets {
+		sextets[i] = key.sextet(i)
+	}
+	for start := 0; start+len(m.allowed) <= keyChars; start++ {
+		found := true
+		for i, set := range m.allowed {
+			if set>>sextets[start+i]&1 == 0 {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// RegexpMatcher matches public keys against a regular expression
+type RegexpMatcher struct {
+	re      *regexp.Regexp
+	counter *AtomicCounter
+	label   string
+
+	probability func() float64 // calculated once, on first use
+}
+
+// NewRegexpMatcher returns a Matcher for public keys matching re,
+// stopping after limit matches
+func NewRegexpMatcher(re *regexp.Regexp, limit int64) *RegexpMatcher {
+	return &RegexpMatcher{
+		re:      re,
+		counter: &AtomicCounter{Value: limit},
+		probability: sync.OnceValue(func() float64 {
+			// 0 if the regular expression is too complex to analyse
+			p, _ := RegexProbability(re.String())
+			return p
+		}),
+	}
+}
+
+// Probability returns the probability of a random key matching the regular
+// expression, or 0 if it is too complex to calculate
+func (m *RegexpMatcher) Probability() float64 {
+	return m.probability()
+}
+
+// Match reports whether key matches the regular expression
+func (m *RegexpMatcher) Match(key string) bool {
+	return m.re.MatchString(key)
+}
+
+// Name returns the regular expression
+func (m *RegexpMatcher) Name() string {
+	return m.re.String()
+}
+
+// Counter returns the number of matching keys still required
+func (m *RegexpMatcher) Counter() *AtomicCounter {
+	return m.counter
+}
+
+// Label returns the label reported with matches
+func (m *RegexpMatcher) Label() string {
+	return m.label
+}
+
+// SetLabel sets the label reported with matches
+func (m *RegexpMatcher) SetLabel(label string) {
+	m.label = label
+}
@@ -0,0 +1,94 @@
+package keygen
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// metricsPrefix is the prefix of every exported metric name
+const metricsPrefix = "wireguard_vanity_"
+
+// StatsSource is a search reporting its progress, such as a Cruncher or a Coordinator
+type StatsSource interface {
+	Stats() Stats
+}
+
+// MetricsHandler returns an HTTP handler serving the progress of the search in
+// the Prometheus text exposition format, for scraping while FindContext runs
+func (c *Cruncher) MetricsHandler() http.Handler {
+	return NewMetricsHandler(c)
+}
+
+// MetricsHandler returns an HTTP handler serving the progress of all workers in
+// the Prometheus text exposition format, for scraping while Serve runs
+func (co *Coordinator) MetricsHandler() http.Handler {
+	return NewMetricsHandler(co)
+}
+
+// NewMetricsHandler returns an HTTP handler serving the stats of s in the
+// Prometheus text exposition format
+func NewMetricsHandler(s StatsSource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		writeMetrics(w, s.Stats())
+	})
+}
+
+// writeMetrics writes the stats in the Prometheus text exposition format
+func writeMetrics(w io.Writer, s Stats) {
+	metric(w, "keys_tried_total", "counter", "Keys generated and compared against the searches.")
+	fmt.Fprintf(w, "%skeys_tried_total %d\n", metricsPrefix, s.Attempts)
+
+	metric(w, "worker_keys_tried_total", "counter", "Keys generated and compared by each worker.")
+	for i, n := range s.Workers {
+		fmt.Fprintf(w, "%sworker_keys_tried_total{worker=\"%d\"} %d\n", metricsPrefix, i, n)
+	}
+
+	metric(w, "keys_per_second", "gauge", "Average keys compared per second since the search started.")
+	fmt.Fprintf(w, "%skeys_per_second %g\n", metricsPrefix, s.Rate())
+
+	metric(w, "workers", "gauge", "Number of workers generating keys: goroutines, or the remote workers of a coordinator.")
+	fmt.Fprintf(w, "%sworkers %d\n", metricsPrefix, len(s.Workers))
+
+	metric(w, "elapsed_seconds", "gauge", "Seconds since the search started.")
+	fmt.Fprintf(w, "%selapsed_seconds %g\n", metricsPrefix, s.Elapsed.Seconds())
+
+	metric(w, "matches_total", "counter", "Matching keys found for each search term.")
+	for i, t := range s.Terms {
+		fmt.Fprintf(w, "%smatches_total%s %d\n", metricsPrefix, termLabels(i, t), t.Found)
+	}
+
+	metric(w, "remaining_matches", "gauge", "Matching keys still required for each search term.")
+	for i, t := range s.Terms {
+		fmt.Fprintf(w, "%sremaining_matches%s %d\n", metricsPrefix, termLabels(i, t), t.Remaining)
+	}
+}
+
+// metric writes the HELP and TYPE lines of a metric
+func metric(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// termLabels returns the Prometheus labels identifying the i'th search term.
+// The index keeps the series unique if the same term is searched for twice.
+func termLabels(i int, t TermStats) string {
+	return fmt.Sprintf("{search=\"%d\",term=\"%s\",label=\"%s\"}", i, escapeLabel(t.Name), escapeLabel(t.Label))
+}
+
+// labelEscaper escapes label values as required by the text exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,61 @@
+package keygen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NetdevConfig returns a systemd-networkd .netdev file creating the WireGuard interface
+// name for the pair, reading its private key from keyFile, with a [WireGuardPeer]
+// section for each of the configured peers
+func (p Pair) NetdevConfig(name, keyFile string, cfg InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("[NetDev]\n")
+	fmt.Fprintf(&b, "Name=%s\n", name)
+	b.WriteString("Kind=wireguard\n")
+	if p.Label != "" {
+		fmt.Fprintf(&b, "Description=%s\n", p.Label)
+	}
+
+	b.WriteString("\n[WireGuard]\n")
+	fmt.Fprintf(&b, "# PublicKey=%s\n", p.Public)
+	fmt.Fprintf(&b, "PrivateKeyFile=%s\n", keyFile)
+	if cfg.ListenPort > 0 {
+		fmt.Fprintf(&b, "ListenPort=%d\n", cfg.ListenPort)
+	}
+
+	for _, peer := range cfg.Peers {
+		b.WriteString("\n[WireGuardPeer]\n")
+		fmt.Fprintf(&b, "PublicKey=%s\n", peer.PublicKey)
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&b, "Endpoint=%s\n", peer.Endpoint)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(&b, "AllowedIPs=%s\n", strings.Join(peer.AllowedIPs, ","))
+		}
+	}
+
+	return b.String()
+}
+
+// NetworkConfig returns a systemd-networkd .network file configuring the addresses
+// and DNS servers of the WireGuard interface name
+func (p Pair) NetworkConfig(name string, cfg InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("[Match]\n")
+	fmt.Fprintf(&b, "Name=%s\n", name)
+
+	b.WriteString("\n[Network]\n")
+	for _, a := range cfg.Address {
+		fmt.Fprintf(&b, "Address=%s\n", a)
+	}
+	servers, domains := splitDNS(cfg.DNS)
+	for _, d := range servers {
+		fmt.Fprintf(&b, "DNS=%s\n", d)
+	}
+	if len(domains) > 0 {
+		fmt.Fprintf(&b, "Domains=%s\n", strings.Join(domains, " "))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package keygen
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// NMConnection returns a NetworkManager keyfile (.nmconnection) for a WireGuard
+// connection with the interface name for the pair, with a [wireguard-peer] section
+// for each of the configured peers. The connection's UUID is derived from the
+// public key, so it is the same every time the file is generated for the pair.
+func (p Pair) NMConnection(name string, cfg InterfaceConfig) string {
+	var b strings.Builder
+	b.WriteString("[connection]\n")
+	id := name
+	if p.Label != "" {
+		id = p.Label
+	}
+	fmt.Fprintf(&b, "id=%s\n", id)
+	fmt.Fprintf(&b, "uuid=%s\n", connectionUUID(p.Public))
+	b.WriteString("type=wireguard\n")
+	fmt.Fprintf(&b, "interface-name=%s\n", name)
+
+	b.WriteString("\n[wireguard]\n")
+	fmt.Fprintf(&b, "# public-key=%s\n", p.Public)
+	fmt.Fprintf(&b, "private-key=%s\n", p.PrivateBytes())
+	if cfg.ListenPort > 0 {
+		fmt.Fprintf(&b, "listen-port=%d\n", cfg.ListenPort)
+	}
+
+	for _, peer := range cfg.Peers {
+		fmt.Fprintf(&b, "\n[wireguard-peer.%s]\n", peer.PublicKey)
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&b, "endpoint=%s\n", peer.Endpoint)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(&b, "allowed-ips=%s;\n", strings.Join(peer.AllowedIPs, ";"))
+		}
+	}
+
+	servers, domains := splitDNS(cfg.DNS)
+	for _, family := range []string{"ipv4", "ipv6"} {
+		is4 := family == "ipv4"
+		var addrs, dns []string
+		for _, a := range cfg.Address {
+			if prefix, err := parseAddress(a); err == nil && prefix.Addr().Is4() == is4 {
+				addrs = append(addrs, prefix.String())
+			}
+		}
+		for _, d := range servers {
+			if addr, err := netip.ParseAddr(d); err == nil && addr.Is4() == is4 {
+				dns = append(dns, d)
+			}
+		}
+
+		fmt.Fprintf(&b, "\n[%s]\n", family)
+		if len(addrs) == 0 {
+			b.WriteString("method=disabled\n")
+			continue
+		}
+		for i, a := range addrs {
+			fmt.Fprintf(&b, "address%d=%s\n", i+1, a)
+		}
+		if len(dns) > 0 {
+			fmt.Fprintf(&b, "dns=%s;\n", strings.Join(dns, ";"))
+		}
+		if len(domains) > 0 {
+			// search domains apply to both address families
+			fmt.Fprintf(&b, "dns-search=%s;\n", strings.Join(domains, ";"))
+			domains = nil
+		}
+		b.WriteString("method=manual\n")
+	}
+
+	return b.String()
+}
+
+// connectionUUID returns a UUID (version 8, RFC 9562) derived from the public key
+func connectionUUID(public string) string {
+	h := sha256.Sum256([]byte(public))
+	h[6] = h[6]&0x0f | 0x80 // version 8
+	h[8] = h[8]&0x3f | 0x80 // RFC 9562 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
@@ -0,0 +1,181 @@
+package keygen
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"regexp/syntax"
+	"slices"
+)
+
+// maxRegexStates is the number of distinct automaton states tracked per
+// character before a regular expression is considered too complex to analyse
+const maxRegexStates = 100000
+
+// errRegexTooComplex is returned when a regular expression has too many states to analyse
+var errRegexTooComplex = errors.New("the regular expression is too complex to calculate its probability")
+
+// regexState is a set of automaton threads waiting to consume the next
+// character, along with the probability of reaching it
+type regexState struct {
+	pcs  []uint32 // program counters, before following empty transitions
+	prev rune     // representative of the previous character, for word boundaries
+	p    float64
+}
+
+// RegexProbability returns the probability that the regular expression matches
+// a random public key, by running its automaton over every possible key.
+// Each of the first 41 characters of a key can be any of the 64 base64
+// characters, the 42nd and 43rd characters are restricted to 32 and 16 values,
+// and the 44th is always =. Regular expressions use Go's regexp syntax.
+func RegexProbability(expr string) (float64, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return 0, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0, err
+	}
+
+	var matched float64
+	states := []*regexState{{prev: -1, p: 1}}
+	var closure []uint32
+	var buf []byte
+	onStack := make([]bool, len(prog.Inst))
+
+	for pos := 0; pos <= keyChars+1; pos++ {
+		chars := positionChars(pos)
+		q := 1 / float64(len(chars))
+		index := make(map[string]int)
+		var next []*regexState
+
+		for _, st := range states {
+			for _, c := range chars {
+				flags := syntax.EmptyOpContext(st.prev, c)
+
+				// follow empty transitions from the waiting threads, and from a
+				// new thread as the match may start at any position
+				closure = closure[:0]
+				accepted := followEmpty(prog, uint32(prog.Start), flags, &closure, onStack)
+				for _, pc := range st.pcs {
+					if followEmpty(prog, pc, flags, &closure, onStack) {
+						accepted = true
+					}
+				}
+				for _, pc := range closure {
+					onStack[pc] = false
+				}
+				if accepted {
+					matched += st.p * q
+					continue
+				}
+				if c == -1 {
+					continue
+				}
+
+				// consume the character
+				var pcs []uint32
+				for _, pc := range closure {
+					inst := &prog.Inst[pc]
+					if consumes(inst, c) && !slices.Contains(pcs, inst.Out) {
+						pcs = append(pcs, inst.Out)
+					}
+				}
+				slices.Sort(pcs)
+
+				// only whether the previous character is a word character matters
+				prev := '+'
+				if isWordChar(c) {
+					prev = 'a'
+				}
+				buf = binary.LittleEndian.AppendUint32(buf[:0], uint32(prev))
+				for _, pc := range pcs {
+					buf = binary.LittleEndian.AppendUint32(buf, pc)
+				}
+				key := string(buf)
+				if i, ok := index[key]; ok {
+					next[i].p += st.p * q
+					continue
+				}
+				if len(next) >= maxRegexStates {
+					return 0, errRegexTooComplex
+				}
+				index[key] = len(next)
+				next = append(next, &regexState{pcs: pcs, prev: prev, p: st.p * q})
+			}
+		}
+
+		states = next
+	}
+
+	return math.Min(matched, 1), nil
+}
+
+// positionChars returns the possible characters at position pos of a base64-encoded
+// key, each equally likely, with -1 representing the end of the key
+func positionChars(pos int) []rune {
+	switch {
+	case pos == keyChars:
+		return []rune{'='}
+	case pos > keyChars:
+		return []rune{-1}
+	}
+
+	set := positionSextets(pos)
+	var chars []rune
+	for i := 0; i < 64; i++ {
+		if set>>i&1 == 1 {
+			chars = append(chars, rune(base64Alphabet[i]))
+		}
+	}
+	return chars
+}
+
+// followEmpty adds pc, and every instruction reachable from it without consuming
+// a character, to closure. It returns true if a match is reached.
+func followEmpty(prog *syntax.Prog, pc uint32, flags syntax.EmptyOp, closure *[]uint32, onStack []bool) bool {
+	if onStack[pc] {
+		return false
+	}
+	onStack[pc] = true
+	*closure = append(*closure, pc)
+
+	inst := &prog.Inst[pc]
+	switch inst.Op {
+	case syntax.InstMatch:
+		return true
+	case syntax.InstAlt, syntax.InstAltMatch:
+		a := followEmpty(prog, inst.Out, flags, closure, onStack)
+		b := followEmpty(prog, inst.Arg, flags, closure, onStack)
+		return a || b
+	case syntax.InstCapture, syntax.InstNop:
+		return followEmpty(prog, inst.Out, flags, closure, onStack)
+	case syntax.InstEmptyWidth:
+		if syntax.EmptyOp(inst.Arg)&^flags == 0 {
+			return followEmpty(prog, inst.Out, flags, closure, onStack)
+		}
+	}
+
+	return false
+}
+
+// consumes returns true if the instruction consumes the character c
+func consumes(inst *syntax.Inst, c rune) bool {
+	switch inst.Op {
+	case syntax.InstRune:
+		return inst.MatchRune(c)
+	case syntax.InstRune1:
+		return c == inst.Rune[0]
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return c != '\n'
+	}
+	return false
+}
+
+// isWordChar returns true if c is an ASCII word character, as used by \b
+func isWordChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,53 @@
+package keygen
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NewSearch returns a Matcher for a search term as given on the command line,
+// stopping after limit matches. Terms containing regular expression meta
+// characters are regular expressions, matched case-insensitively unless
+// caseSensitive is set. Other terms are literals matched in the given mode.
+// The error describes why the term is invalid, or can never match a key.
+func NewSearch(term string, caseSensitive bool, mode MatchMode, limit int64) (Matcher, error) {
+	if !IsRegex(term) {
+		if errMsg := IsValidLiteral(term, caseSensitive, mode); errMsg != "" {
+			return nil, searchError(errMsg)
+		}
+		return NewMatcher(term, caseSensitive, mode, limit), nil
+	}
+
+	if errMsg := IsValidRegex(term); errMsg != "" {
+		return nil, searchError(errMsg)
+	}
+
+	expr := searchRegexp(term, caseSensitive)
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("\"%s\" is an invalid regular expression: %v", term, err)
+	}
+	if p, err := RegexProbability(expr); err == nil && p == 0 {
+		return nil, fmt.Errorf("\"%s\" will never match", term)
+	}
+
+	return NewRegexpMatcher(re, limit), nil
+}
+
+// searchRegexp returns the regular expression matched for a search term
+func searchRegexp(term string, caseSensitive bool) string {
+	// leading and trailing .* are implied
+	expr := strings.TrimSuffix(strings.TrimPrefix(term, ".*"), ".*")
+	if !caseSensitive && !strings.HasPrefix(expr, "(?i)") {
+		expr = "(?i)" + expr
+	}
+
+	return expr
+}
+
+// searchError converts a validation message to an error
+func searchError(msg string) error {
+	return errors.New(strings.TrimPrefix(msg, "\n"))
+}
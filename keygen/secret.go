@@ -0,0 +1,134 @@
+package keygen
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// encodedKeySize is the length of a base64-encoded key
+const encodedKeySize = 44
+
+// secretPageSize is the size of the locked memory secrets are carved from
+const secretPageSize = 4096
+
+// Secret is a base64-encoded private key held in locked memory, see lockedAlloc.
+// It is shared by every copy of the Pair it belongs to. Its memory is reused once
+// the Secret is no longer referenced, so callers need not wipe it.
+type Secret struct {
+	b     *[encodedKeySize]byte
+	wiped atomic.Bool // shared by the copies of a Pair, which may be used concurrently
+}
+
+// secrets holds the unused slots of locked memory. Locked pages are never
+// unmapped, so memory a stale reference points to is zeros or another key,
+// and reading it cannot crash the process.
+var secrets struct {
+	mu   sync.Mutex
+	free []*[encodedKeySize]byte
+}
+
+// newSecret returns the base64-encoded private key in locked memory
+func newSecret(k *PrivateKey) *Secret {
+	s := &Secret{b: allocSecret()}
+	base64.StdEncoding.Encode(s.b[:], k[:])
+	runtime.AddCleanup(s, freeSecret, s.b)
+
+	return s
+}
+
+// allocSecret returns an unused slot of locked memory, locking another page if none is left
+func allocSecret() *[encodedKeySize]byte {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	if len(secrets.free) == 0 {
+		page := lockedAlloc(secretPageSize)
+		for i := 0; i+encodedKeySize <= len(page); i += encodedKeySize {
+			secrets.free = append(secrets.free, (*[encodedKeySize]byte)(page[i:i+encodedKeySize]))
+		}
+	}
+	b := secrets.free[len(secrets.free)-1]
+	secrets.free = secrets.free[:len(secrets.free)-1]
+
+	return b
+}
+
+// freeSecret overwrites the slot with zeros and returns it for reuse
+func freeSecret(b *[encodedKeySize]byte) {
+	clear(b[:])
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	secrets.free = append(secrets.free, b)
+}
+
+// Bytes returns the private key, nil once the secret has been wiped. The slice
+// refers to the locked memory, so it must not be kept after the Secret.
+func (s *Secret) Bytes() []byte {
+	if s == nil || s.wiped.Load() {
+		return nil
+	}
+	return s.b[:]
+}
+
+// Wipe overwrites the private key with zeros
+func (s *Secret) Wipe() {
+	if s == nil {
+		return
+	}
+	s.wiped.Store(true)
+	clear(s.b[:])
+}
+
+// newPair returns the pair for a match, holding the private key only in
+// locked memory until Wipe is called, see Secret
+func newPair(k *PrivateKey, pub *Key, m Matcher) Pair {
+	return Pair{
+		Public: pub.String(),
+		Term:   m.Name(),
+		Label:  labelOf(m),
+		secret: newSecret(k),
+	}
+}
+
+// Secret returns the private key held in locked memory, nil if the pair was not
+// returned by a search, see PrivateBytes
+func (p Pair) Secret() *Secret {
+	return p.secret
+}
+
+// PrivateBytes returns the base64-encoded private key: the Secret of a pair
+// returned by a search, which leaves Private empty, or otherwise Private.
+// It returns nil once the pair has been wiped. The slice must not be modified,
+// or kept after the pair.
+func (p Pair) PrivateBytes() []byte {
+	if p.secret != nil {
+		return p.secret.Bytes()
+	}
+	if p.Private == "" {
+		return nil
+	}
+	return []byte(p.Private)
+}
+
+// MarshalJSON encodes the pair with its private key, see PrivateBytes
+func (p Pair) MarshalJSON() ([]byte, error) {
+	type pair Pair // without the MarshalJSON method
+	q := pair(p)
+	q.Private = string(p.PrivateBytes())
+	return json.Marshal(q)
+}
+
+// Wipe overwrites the pair's Secret with zeros, which is shared with its copies,
+// and clears Private
+func (p *Pair) Wipe() {
+	p.secret.Wipe()
+	p.secret = nil
+	p.Private = ""
+}
+
+// Wipe overwrites the private key with zeros
+func (k *PrivateKey) Wipe() {
+	clear(k[:])
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package keygen
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// lockedAlloc returns a zeroed buffer of n bytes outside the Go heap, locked into
+// RAM so it is never written to swap, and never released. If the memory cannot be
+// locked, eg: once RLIMIT_MEMLOCK is reached, the buffer is returned unlocked.
+func lockedAlloc(n int) []byte {
+	b, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, n)
+	}
+	_ = unix.Mlock(b)
+	// keep the secret out of core dumps
+	_ = unix.Madvise(b, unix.MADV_DONTDUMP)
+
+	return b
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package keygen
+
+// lockedAlloc returns a zeroed buffer of n bytes. Memory is only locked on Linux.
+func lockedAlloc(n int) []byte {
+	return make([]byte, n)
+}
@@ -0,0 +1,544 @@
+package keygen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxJobRequestSize is the maximum size of a job request body
+const maxJobRequestSize = 1 << 20
+
+// JobRequest is a request to find vanity keys, submitted to a Service
+type JobRequest struct {
+	Terms         []string `json:"terms"` // with optional settings, eg: VPN:cs:limit=1
+	CaseSensitive bool     `json:"case_sensitive,omitempty"`
+	Mode          string   `json:"mode,omitempty"`    // prefix (default), suffix or contains
+	Limit         int      `json:"limit,omitempty"`   // results per term (default 1)
+	Timeout       string   `json:"timeout,omitempty"` // eg: 30m, counted from when the job starts running
+	Cores         int      `json:"cores,omitempty"`   // CPU cores used by the job (default 1)
+	Incremental   bool     `json:"incremental,omitempty"`
+}
+
+// JobState is the state of a job
+type JobState string
+
+const (
+	// JobQueued means the job is waiting for CPU cores
+	JobQueued JobState = "queued"
+	// JobRunning means the job is searching for keys
+	JobRunning JobState = "running"
+	// JobFinished means all the job's searches were satisfied
+	JobFinished JobState = "finished"
+	// JobTimedOut means the job's timeout passed before all searches were satisfied
+	JobTimedOut JobState = "timed out"
+	// JobCancelled means the job was cancelled
+	JobCancelled JobState = "cancelled"
+)
+
+// done returns true if the job has stopped
+func (s JobState) done() bool {
+	return s != JobQueued && s != JobRunning
+}
+
+// JobStatus is the status of a job, as returned by a Service
+type JobStatus struct {
+	ID       string     `json:"id"`
+	State    JobState   `json:"state"`
+	Request  JobRequest `json:"request"`
+	Results  []Pair     `json:"results"`
+	Attempts uint64     `json:"attempts"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Service runs vanity key jobs submitted over a small REST API, each backed by
+// its own Cruncher. Jobs share a limited number of CPU cores, and wait in turn
+// until enough cores are free.
+//
+//	POST   /jobs              submit a JobRequest, returning its JobStatus
+//	GET    /jobs              list all jobs
+//	GET    /jobs/{id}         poll a job's JobStatus
+//	GET    /jobs/{id}/results stream the job's results as newline-delimited JSON until it stops
+//	DELETE /jobs/{id}         cancel a job
+//
+// Results include private keys, so the API must only be reachable by trusted clients.
+type Service struct {
+	// Retention is how long stopped jobs are kept for
+	Retention time.Duration
+
+	maxCores int
+	cores    coreLimiter
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	mux      *http.ServeMux
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewService returns a Service using at most cores CPU cores for all of its jobs
+func NewService(cores int) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		Retention: time.Hour,
+		maxCores:  cores,
+		cores:     coreLimiter{free: cores},
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*job),
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /jobs", s.handleSubmit)
+	s.mux.HandleFunc("GET /jobs", s.handleList)
+	s.mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	s.mux.HandleFunc("GET /jobs/{id}/results", s.handleResults)
+	s.mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+
+	return s
+}
+
+// ServeHTTP serves the REST API
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels all jobs and waits for them to stop
+func (s *Service) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Submit validates the request and starts the job, returning its status
+func (s *Service) Submit(req JobRequest) (JobStatus, error) {
+	if len(req.Terms) == 0 {
+		return JobStatus{}, errors.New("no search terms")
+	}
+	if req.Limit <= 0 {
+		req.Limit = 1
+	}
+	if req.Cores <= 0 {
+		req.Cores = 1
+	}
+	if req.Cores > s.maxCores {
+		return JobStatus{}, fmt.Errorf("at most %d CPU %s can be used", s.maxCores, Plural("core", int64(s.maxCores)))
+	}
+	mode, err := ParseMatchMode(req.Mode)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout < 0 {
+			return JobStatus{}, fmt.Errorf("invalid timeout %q", req.Timeout)
+		}
+	}
+
+	c := New(Options{
+		LimitResults:  req.Limit,
+		Threads:       req.Cores,
+		Cores:         req.Cores,
+		CaseSensitive: req.CaseSensitive,
+		Incremental:   req.Incremental,
+	}, timeout)
+	def := SearchTerm{CaseSensitive: req.CaseSensitive, Mode: mode, Limit: int64(req.Limit)}
+	for _, term := range req.Terms {
+		t, err := ParseTerm(term, def)
+		if err != nil {
+			return JobStatus{}, err
+		}
+		m, err := t.Matcher()
+		if err != nil {
+			return JobStatus{}, err
+		}
+		c.AddMatcher(m)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return JobStatus{}, err
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &job{
+		id:       id,
+		req:      req,
+		cruncher: c,
+		cancel:   cancel,
+		state:    JobQueued,
+		changed:  make(chan struct{}),
+		created:  time.Now(),
+	}
+
+	s.mu.Lock()
+	s.prune()
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.run(ctx, j, timeout)
+	}()
+
+	return j.status(), nil
+}
+
+// run waits for CPU cores and runs the job until it stops
+func (s *Service) run(ctx context.Context, j *job, timeout time.Duration) {
+	if err := s.cores.acquire(ctx, j.req.Cores); err != nil {
+		j.stop(JobCancelled)
+		return
+	}
+	defer s.cores.release(j.req.Cores)
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	j.start()
+	err := j.cruncher.FindContext(ctx, j.add)
+
+	var findErr *FindError
+	switch {
+	case err == nil:
+		j.stop(JobFinished)
+	case errors.As(err, &findErr) && findErr.Status == TimedOut:
+		j.stop(JobTimedOut)
+	default:
+		j.stop(JobCancelled)
+	}
+}
+
+// Job returns the status of a job
+func (s *Service) Job(id string) (JobStatus, bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return JobStatus{}, false
+	}
+
+	return j.status(), true
+}
+
+// Cancel cancels a job, returning its status
+func (s *Service) Cancel(id string) (JobStatus, bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		return JobStatus{}, false
+	}
+	j.cancel()
+	j.wait()
+
+	return j.status(), true
+}
+
+// prune removes jobs which stopped more than Retention ago. s.mu must be held.
+func (s *Service) prune() {
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		expired := j.state.done() && time.Since(j.finished) > s.Retention
+		j.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// handleSubmit handles POST /jobs
+func (s *Service) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+
+	st, err := s.Submit(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+st.ID)
+	writeJSON(w, http.StatusCreated, st)
+}
+
+// handleList handles GET /jobs
+func (s *Service) handleList(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	s.prune()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+
+	list := make([]JobStatus, len(jobs))
+	for i, j := range jobs {
+		list[i] = j.status()
+	}
+	slices.SortFunc(list, func(a, b JobStatus) int {
+		return a.Created.Compare(b.Created)
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleStatus handles GET /jobs/{id}
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleCancel handles DELETE /jobs/{id}
+func (s *Service) handleCancel(w http.ResponseWriter, r *http.Request) {
+	st, ok := s.Cancel(r.PathValue("id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleResults handles GET /jobs/{id}/results, writing every result as a line
+// of JSON as soon as it is found, until the job stops or the client goes away
+func (s *Service) handleResults(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("job not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for sent := 0; ; {
+		j.mu.Lock()
+		results := j.results[sent:]
+		done := j.state.done()
+		changed := j.changed
+		j.mu.Unlock()
+
+		for _, p := range results {
+			if err := enc.Encode(p); err != nil {
+				return
+			}
+		}
+		sent += len(results)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes err as a JSON error response
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// job is a single job run by a Service
+type job struct {
+	id       string
+	req      JobRequest
+	cruncher *Cruncher
+	cancel   context.CancelFunc
+
+	mu       sync.Mutex
+	state    JobState
+	results  []Pair
+	changed  chan struct{} // closed and replaced whenever the results or state change
+	created  time.Time
+	started  time.Time
+	finished time.Time
+}
+
+// notify wakes up everyone waiting for a change. j.mu must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// start marks the job as running
+func (j *job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = JobRunning
+	j.started = time.Now()
+	j.notify()
+}
+
+// add records a result. Results are kept until the job is pruned, so the
+// private key is copied out of locked memory, which is wiped straight away.
+func (j *job) add(p Pair) {
+	kept := p
+	kept.Private = string(p.PrivateBytes())
+	kept.secret = nil
+	p.Wipe()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.results = append(j.results, kept)
+	j.notify()
+}
+
+// stop marks the job as stopped
+func (j *job) stop(state JobState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = state
+	j.finished = time.Now()
+	j.notify()
+}
+
+// wait waits for the job to stop
+func (j *job) wait() {
+	for {
+		j.mu.Lock()
+		done, changed := j.state.done(), j.changed
+		j.mu.Unlock()
+		if done {
+			return
+		}
+		<-changed
+	}
+}
+
+// status returns a snapshot of the job's status
+func (j *job) status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := JobStatus{
+		ID:       j.id,
+		State:    j.state,
+		Request:  j.req,
+		Results:  slices.Clone(j.results),
+		Attempts: j.cruncher.Stats().Attempts,
+		Created:  j.created,
+	}
+	if st.Results == nil {
+		st.Results = []Pair{}
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		st.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		st.Finished = &finished
+	}
+
+	return st
+}
+
+// coreLimiter shares a number of CPU cores between jobs, granting them in the
+// order they were asked for
+type coreLimiter struct {
+	mu      sync.Mutex
+	free    int
+	waiters []*coreWaiter
+}
+
+// coreWaiter is a job waiting for CPU cores
+type coreWaiter struct {
+	n     int
+	ready chan struct{} // closed once the cores are granted
+}
+
+// acquire waits until n cores are free and takes them, or until ctx is done
+func (l *coreLimiter) acquire(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if len(l.waiters) == 0 && l.free >= n {
+		l.free -= n
+		l.mu.Unlock()
+		return nil
+	}
+	w := &coreWaiter{n: n, ready: make(chan struct{})}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// granted in the meantime, give the cores back
+		l.free += n
+	default:
+		l.waiters = slices.DeleteFunc(l.waiters, func(o *coreWaiter) bool { return o == w })
+	}
+	l.grant()
+
+	return ctx.Err()
+}
+
+// release returns n cores
+func (l *coreLimiter) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.free += n
+	l.grant()
+}
+
+// grant hands free cores to the waiting jobs in turn. l.mu must be held.
+func (l *coreLimiter) grant() {
+	for len(l.waiters) > 0 && l.waiters[0].n <= l.free {
+		w := l.waiters[0]
+		l.free -= w.n
+		close(w.ready)
+		l.waiters = l.waiters[1:]
+	}
+}
@@ -0,0 +1,107 @@
+package keygen
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// workerStats holds the progress of a single worker goroutine
+type workerStats struct {
+	attempts atomic.Uint64
+	_        [56]byte // keep the counters of different workers on separate cache lines
+}
+
+// Stats is a snapshot of the progress of a search
+type Stats struct {
+	Attempts uint64        // keys compared by all workers
+	Workers  []uint64      // keys compared by each worker
+	Elapsed  time.Duration // time since the search started
+	Terms    []TermStats   // progress of each search, in the order they were added
+}
+
+// TermStats is a snapshot of the progress of a single search
+type TermStats struct {
+	Name        string
+	Label       string
+	Found       int64   // matches found
+	Remaining   int64   // matches still required
+	Probability float64 // probability of a single key matching, 0 if unknown
+	// Likelihood is the probability that at least one match should have been
+	// found by now, given the keys compared. It is 0 if Probability is unknown.
+	Likelihood float64
+}
+
+// Rate returns the average number of keys compared per second
+func (s Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Attempts) / s.Elapsed.Seconds()
+}
+
+// resetStats starts counting the progress of a new search,
+// returning the counters of its workers
+func (c *Cruncher) resetStats() []workerStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	c.started = time.Now()
+	c.workers = make([]workerStats, c.Cores)
+
+	return c.workers
+}
+
+// Stats returns a snapshot of the progress of the current or last search.
+// It is safe to call while FindContext is running.
+func (c *Cruncher) Stats() Stats {
+	c.statsMu.Lock()
+	started, workers := c.started, c.workers
+	c.statsMu.Unlock()
+
+	var s Stats
+	if !started.IsZero() {
+		s.Elapsed = time.Since(started)
+	}
+	s.Workers = make([]uint64, len(workers))
+	for i := range workers {
+		s.Workers[i] = workers[i].attempts.Load()
+		s.Attempts += s.Workers[i]
+	}
+
+	s.Terms = c.termStats(s.Attempts)
+
+	return s
+}
+
+// termStats returns the progress of each search after attempts keys
+func (c *Cruncher) termStats(attempts uint64) []TermStats {
+	terms := make([]TermStats, len(c.matchers))
+	for i, m := range c.matchers {
+		remaining := max(m.Counter().Get(), 0)
+		t := TermStats{
+			Name:      m.Name(),
+			Label:     labelOf(m),
+			Found:     max(c.limits[i]-remaining, 0),
+			Remaining: remaining,
+		}
+		if e, ok := m.(Estimator); ok {
+			t.Probability = e.Probability()
+			t.Likelihood = likelihood(t.Probability, attempts)
+		}
+		terms[i] = t
+	}
+
+	return terms
+}
+
+// likelihood returns the probability of at least one match in n keys,
+// when each matches with probability p
+func likelihood(p float64, n uint64) float64 {
+	if p <= 0 {
+		return 0
+	}
+	if p >= 1 {
+		return 1
+	}
+	return -math.Expm1(float64(n) * math.Log1p(-p))
+}
@@ -0,0 +1,121 @@
+package keygen
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Match is a match as written by a StreamWriter
+type Match struct {
+	Pair
+	Time     time.Time `json:"time"`     // when the match was found
+	Attempts uint64    `json:"attempts"` // keys tried when the match was found
+}
+
+// plainMatch is a match as written by a StreamWriter
+type plainMatch struct {
+	Private  string    `json:"private"`
+	Public   string    `json:"public"`
+	Term     string    `json:"term,omitempty"`
+	Label    string    `json:"label,omitempty"`
+	Time     time.Time `json:"time"`
+	Attempts uint64    `json:"attempts"`
+}
+
+// MarshalJSON encodes the match with its private key, see Pair.PrivateBytes
+func (m Match) MarshalJSON() ([]byte, error) {
+	return json.Marshal(plainMatch{
+		Private:  string(m.PrivateBytes()),
+		Public:   m.Public,
+		Term:     m.Term,
+		Label:    m.Label,
+		Time:     m.Time,
+		Attempts: m.Attempts,
+	})
+}
+
+// encryptedMatch is a match as written by a StreamWriter with an Encryptor,
+// with the private key encrypted in age's binary format, base64-encoded
+type encryptedMatch struct {
+	PrivateAge string    `json:"private_age"`
+	Public     string    `json:"public"`
+	Term       string    `json:"term,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	Time       time.Time `json:"time"`
+	Attempts   uint64    `json:"attempts"`
+}
+
+// StreamWriter writes matches as newline-delimited JSON, one object per line,
+// as soon as they are found. Lines written to a regular file are synced to
+// stable storage before Write returns, so no match is lost if the process dies.
+// It is safe for concurrent use.
+type StreamWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	sync bool
+	enc  *Encryptor
+}
+
+// NewStreamWriter returns a StreamWriter writing to w
+func NewStreamWriter(w io.Writer) *StreamWriter {
+	s := &StreamWriter{w: w}
+	if f, ok := w.(*os.File); ok {
+		// syncing pipes and terminals fails
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			s.sync = true
+		}
+	}
+
+	return s
+}
+
+// EncryptTo encrypts the private key of every match written from now on,
+// replacing the private field of each line with private_age
+func (s *StreamWriter) EncryptTo(e *Encryptor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enc = e
+}
+
+// Write writes the match as a single line
+func (s *StreamWriter) Write(m Match) error {
+	s.mu.Lock()
+	enc := s.enc
+	s.mu.Unlock()
+
+	var v any = m
+	if enc != nil {
+		private, err := enc.Encrypt(m.PrivateBytes())
+		if err != nil {
+			return err
+		}
+		v = encryptedMatch{
+			PrivateAge: base64.StdEncoding.EncodeToString(private),
+			Public:     m.Public,
+			Term:       m.Term,
+			Label:      m.Label,
+			Time:       m.Time,
+			Attempts:   m.Attempts,
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	if s.sync {
+		return s.w.(*os.File).Sync()
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package keygen
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the data a Template renders a match with: the fields of
+// Match, eg: {{.Private}}, {{.Public}}, {{.Term}}, {{.Label}}, {{.Time}} and
+// {{.Attempts}}, and the index of the match
+type TemplateData struct {
+	Match
+	Private string // private key of the match, see Pair.PrivateBytes
+	Index   int    // number of matches rendered before this one, counting from 0
+}
+
+// Template renders matches with a user-defined text/template, so results can
+// be written in any format. A second template can name a file for each match.
+// It is safe for concurrent use.
+type Template struct {
+	text *template.Template
+	name *template.Template // nil if all matches are written to one file
+
+	mu   sync.Mutex
+	next int // index of the next match
+}
+
+// ParseTemplate parses text as the template every match is rendered with,
+// and name, if not empty, as the template naming the file of each match,
+// eg: {{.Label}}.conf. Both are rendered with an example match, so errors
+// such as unknown fields are reported before the search starts.
+func ParseTemplate(text, name string) (*Template, error) {
+	t := &Template{}
+	var err error
+	if t.text, err = template.New("template").Parse(text); err != nil {
+		return nil, err
+	}
+	if name != "" {
+		if t.name, err = template.New("name").Parse(name); err != nil {
+			return nil, err
+		}
+	}
+
+	key := strings.Repeat("A", encodedKeySize-1) + "="
+	example := Match{Pair: Pair{Private: key, Public: key, Term: "term", Label: "label"}, Time: time.Now()}
+	if _, _, err := t.render(TemplateData{Match: example, Private: key}); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// PerMatch reports whether the template names a file for each match
+func (t *Template) PerMatch() bool {
+	return t.name != nil
+}
+
+// Render renders the match, returning the name of its file, empty unless
+// PerMatch, and its output. Matches are indexed in the order they are rendered.
+func (t *Template) Render(m Match) (string, []byte, error) {
+	t.mu.Lock()
+	data := TemplateData{Match: m, Private: string(m.PrivateBytes()), Index: t.next}
+	t.next++
+	t.mu.Unlock()
+
+	return t.render(data)
+}
+
+// render renders the data with the name and text templates
+func (t *Template) render(data TemplateData) (string, []byte, error) {
+	var name string
+	if t.name != nil {
+		var buf strings.Builder
+		if err := t.name.Execute(&buf, data); err != nil {
+			return "", nil, err
+		}
+		if name = strings.TrimSpace(buf.String()); name == "" {
+			return "", nil, errors.New("empty file name")
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := t.text.Execute(&buf, data); err != nil {
+		clear(buf.Bytes())
+		return "", nil, err
+	}
+
+	return name, buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package keygen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// SearchTerm is a search term with its own settings
+type SearchTerm struct {
+	Term          string
+	CaseSensitive bool
+	Mode          MatchMode
+	Limit         int64  // number of matches to find
+	Label         string // reported with matches
+	Line          int    // line of the terms file, 0 if not read from a file
+}
+
+// Matcher returns a Matcher for the search term, see NewSearch
+func (t SearchTerm) Matcher() (Matcher, error) {
+	m, err := NewSearch(t.Term, t.CaseSensitive, t.Mode, t.Limit)
+	if err != nil {
+		return nil, err
+	}
+	SetLabel(m, t.Label)
+
+	return m, nil
+}
+
+// ParseTerm parses a search term followed by settings separated by colons,
+// eg: VPN:cs:limit=1, overriding those of def. See ParseTerms for the settings.
+// Colons never appear in valid search terms. The term itself is not validated.
+func ParseTerm(s string, def SearchTerm) (SearchTerm, error) {
+	fields := strings.Split(s, ":")
+	t := def
+	t.Term = fields[0]
+	for _, setting := range fields[1:] {
+		if err := t.apply(setting); err != nil {
+			return SearchTerm{}, fmt.Errorf("%q: %w", s, err)
+		}
+	}
+
+	return t, nil
+}
+
+// ParseTerms reads search terms, one per line, each optionally followed by settings
+// separated by whitespace overriding those of def:
+//
+//	limit=N          find N matches
+//	cs, ci           match case-sensitively or case-insensitively
+//	prefix, suffix,
+//	contains         match the start, end or anywhere in the key
+//	label=NAME       report NAME with the matches
+//
+// Settings may also follow the term separated by colons, as with ParseTerm.
+// Blank lines and everything after a # are ignored. Every term is validated
+// as on the command line, and errors include the line number.
+func ParseTerms(r io.Reader, def SearchTerm) ([]SearchTerm, error) {
+	var terms []SearchTerm
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		t, err := ParseTerm(fields[0], def)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		t.Line = line
+		for _, setting := range fields[1:] {
+			if err := t.apply(setting); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if _, err := t.Matcher(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		terms = append(terms, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return terms, nil
+}
+
+// apply overrides a setting of the search term
+func (t *SearchTerm) apply(setting string) error {
+	key, value, hasValue := strings.Cut(strings.TrimSpace(setting), "=")
+	switch {
+	case key == "limit" && hasValue:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid limit %q", value)
+		}
+		t.Limit = n
+	case key == "label" && hasValue && value != "":
+		t.Label = value
+	case hasValue || key == "":
+		return fmt.Errorf("unknown setting %q", setting)
+	case key == "cs" || key == "case-sensitive":
+		t.CaseSensitive = true
+	case key == "ci" || key == "case-insensitive":
+		t.CaseSensitive = false
+	default:
+		mode, err := ParseMatchMode(key)
+		if err != nil {
+			return fmt.Errorf("unknown setting %q", setting)
+		}
+		t.Mode = mode
+	}
+
+	return nil
+}
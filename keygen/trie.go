@@ -0,0 +1,137 @@
+package keygen
+
+import (
+	"sync/atomic"
+)
+
+// base64Alphabet is the standard base64 alphabet used to encode keys
+const base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// base64Index maps a base64 character to its 6-bit value, or 0xff if the
+// character is not part of the alphabet
+var base64Index = func() [256]byte {
+	var t [256]byte
+	for i := range t {
+		t[i] = 0xff
+	}
+	for i := 0; i < len(base64Alphabet); i++ {
+		t[base64Alphabet[i]] = byte(i)
+	}
+	return t
+}()
+
+// trieNode is a node of a prefix trie over base64 characters
+type trieNode struct {
+	next  [64]*trieNode
+	terms []*PrefixMatcher // prefixes ending at this node
+}
+
+// insert adds m to the trie below n. Prefixes which can never match are not inserted.
+func (n *trieNode) insert(m *PrefixMatcher) {
+	if m.allowed == nil {
+		return
+	}
+	for i := 0; i < len(m.term); i++ {
+		c := base64Index[m.term[i]]
+		if n.next[c] == nil {
+			n.next[c] = &trieNode{}
+		}
+		n = n.next[c]
+	}
+	n.terms = append(n.terms, m)
+}
+
+// linearPrefixLimit is the number of prefixes up to which each prefix's
+// bitmask is compared in turn, rather than walking the trie
+const linearPrefixLimit = 4
+
+// foldSextet maps the 6-bit value of an uppercase base64 letter to
+// that of its lowercase equivalent
+var foldSextet = func() [64]byte {
+	var t [64]byte
+	for i := range t {
+		t[i] = byte(i)
+		if i < 26 {
+			t[i] += 26
+		}
+	}
+	return t
+}()
+
+// prefixIndex matches any number of prefixes against a key in a single pass
+// over its first characters, so the cost per key depends on the length of the
+// prefixes rather than on how many there are. Few prefixes are compared
+// directly against the raw key bytes instead.
+type prefixIndex struct {
+	list        []*PrefixMatcher // all prefixes
+	sensitive   trieNode         // case-sensitive prefixes
+	insensitive trieNode         // case-insensitive prefixes, stored in lowercase
+	active      atomic.Int64     // number of prefixes still requiring matches
+}
+
+// add registers a prefix matcher with the index. A prefix which can never match
+// is left out of the trie, but like other matchers it still has to be satisfied,
+// so the search runs until it is stopped.
+func (p *prefixIndex) add(m *PrefixMatcher) {
+	root := &p.insensitive
+	if m.caseSensitive {
+		root = &p.sensitive
+	}
+	root.insert(m)
+	p.list = append(p.list, m)
+	if m.counter.Get() > 0 {
+		p.active.Add(1)
+	}
+}
+
+// completed returns true once every prefix has been satisfied
+func (p *prefixIndex) completed() bool {
+	return p.active.Load() <= 0
+}
+
+// match invokes hit for every prefix of key in the index
+func (p *prefixIndex) match(key *Key, hit func(m *PrefixMatcher)) {
+	if len(p.list) <= linearPrefixLimit {
+		for _, m := range p.list {
+			if m.MatchKey(key) {
+				hit(m)
+			}
+		}
+		return
+	}
+	p.sensitive.walk(key, false, hit)
+	p.insensitive.walk(key, true, hit)
+}
+
+// walk follows the base64 characters of key down the trie below n, invoking
+// hit for every prefix passed on the way. If fold is set, key is matched in lowercase.
+func (n *trieNode) walk(key *Key, fold bool, hit func(m *PrefixMatcher)) {
+	for i := 0; ; i++ {
+		for _, m := range n.terms {
+			hit(m)
+		}
+		// the 43rd character is the last one derived from the key
+		if i == 43 {
+			return
+		}
+		c := key.sextet(i)
+		if fold {
+			c = foldSextet[c]
+		}
+		if n = n.next[c]; n == nil {
+			return
+		}
+	}
+}
+
+// dec records a match for m, returning false if m no longer requires matches
+func (p *prefixIndex) dec(m *PrefixMatcher) bool {
+	if m.counter.Get() <= 0 {
+		return false
+	}
+	v := m.counter.Dec()
+	if v == 0 {
+		p.active.Add(-1)
+	}
+	return v >= 0
+}
@@ -16,6 +16,12 @@ const regexChars = `^$.|?*-[]{}()\`
 // regexWillNeverMatch is a shared error message that the regex will never match
 const regexWillNeverMatch = "The regular expression will never match"
 
+// finalKeyChars are the only characters which can appear before the = at the end of a key
+const finalKeyChars = "048AEIMQUYcgkosw"
+
+// penultimateKeyChars are the only characters which can appear second to last in a key
+const penultimateKeyChars = "0123ABCDEFGHQRSTUVWXghijklmnwxyz"
+
 // IsValidSearch checks the search does not contain any invalid characters
 func IsValidSearch(s string) bool {
 	var r = regexp.MustCompile(`[^a-zA-Z0-9\/\+]`)
@@ -27,41 +33,82 @@ func InvalidSearchMsg(s string) string {
 	return fmt.Sprintf("\n\"%s\" contains invalid characters\nValid characters include letters [a-z], numbers [0-9], + and /", s)
 }
 
+// IsValidLiteral checks a literal search term has any chance of matching a key
+// in the given mode, returning an error message if not
+func IsValidLiteral(s string, caseSensitive bool, mode MatchMode) string {
+	if !IsValidSearch(s) {
+		return InvalidSearchMsg(s)
+	}
+	if len(s) > keyChars {
+		return fmt.Sprintf("\n\"%s\" is too long, keys contain %d searchable characters", s, keyChars)
+	}
+	if CalculateProbability(s, caseSensitive, mode) > 0 {
+		return ""
+	}
+	if mode == MatchSuffix {
+		if !containsChar(finalKeyChars, s[len(s)-1], caseSensitive) {
+			return fmt.Sprintf("\n\"%s\" will never match\nThe last character of a key (before the `=`) is always one of %s", s, finalKeyChars)
+		}
+		if len(s) > 1 && !containsChar(penultimateKeyChars, s[len(s)-2], caseSensitive) {
+			return fmt.Sprintf("\n\"%s\" will never match\nThe second to last character of a key is always one of %s", s, penultimateKeyChars)
+		}
+	}
+
+	return fmt.Sprintf("\n\"%s\" will never match", s)
+}
+
+// containsChar returns true if chars contains c, in either case if not caseSensitive
+func containsChar(chars string, c byte, caseSensitive bool) bool {
+	if caseSensitive {
+		return strings.IndexByte(chars, c) >= 0
+	}
+	return strings.ContainsAny(chars, strings.ToLower(string(c))+strings.ToUpper(string(c)))
+}
+
 // HumanizeDuration returns a human-readable output of time.Duration
 func HumanizeDuration(duration time.Duration) string {
-	// more than duration can handle
-	if duration.Hours() < 0.0 {
-		return "hundreds of years"
+	return HumanizeSeconds(duration.Seconds())
+}
+
+// HumanizeSeconds returns a human-readable output of a number of seconds.
+// Unlike time.Duration it does not overflow, so estimates of millions of
+// years and beyond are written in scientific notation, eg: 3.2e9 years.
+func HumanizeSeconds(seconds float64) string {
+	hours := seconds / 3600
+	if math.IsInf(hours, 1) || math.IsNaN(hours) {
+		return "forever"
 	}
-	if duration.Hours() > 8760.0 {
-		y := int64(duration.Hours() / 8760)
-		return fmt.Sprintf("%d %s", y, Plural("year", y))
+	if hours > 8760.0 {
+		y := hours / 8760
+		if y >= 1e6 {
+			return ScientificFormat(y) + " years"
+		}
+		return fmt.Sprintf("%s %s", NumberFormat(int64(y)), Plural("year", int64(y)))
 	}
-	if duration.Hours() > 720.0 {
-		m := int64(duration.Hours() / 24 / 30)
+	if hours > 720.0 {
+		m := int64(hours / 24 / 30)
 		return fmt.Sprintf("%d %s", m, Plural("month", m))
 	}
-	if duration.Hours() > 168.0 {
-		w := int64(duration.Hours() / 168)
+	if hours > 168.0 {
+		w := int64(hours / 168)
 		return fmt.Sprintf("%d %s", w, Plural("week", w))
 	}
-	if duration.Seconds() < 60.0 {
-		s := int64(duration.Seconds())
+	if seconds < 60.0 {
+		s := int64(max(seconds, 0))
 		return fmt.Sprintf("%d %s", s, Plural("second", s))
 	}
-	if duration.Minutes() < 60.0 {
-		m := int64(duration.Minutes())
+	if seconds < 3600.0 {
+		m := int64(seconds / 60)
 		return fmt.Sprintf("%d %s", m, Plural("minute", m))
 	}
-	if duration.Hours() < 24.0 {
-		m := int64(math.Mod(duration.Minutes(), 60))
-		h := int64(duration.Hours())
+	if hours < 24.0 {
+		m := int64(math.Mod(seconds/60, 60))
+		h := int64(hours)
 		return fmt.Sprintf("%d %s, %d %s",
 			h, Plural("hour", h), m, Plural("minute", m))
 	}
-	// if duration.Hours() <
-	h := int64(math.Mod(duration.Hours(), 24))
-	d := int64(duration.Hours() / 24)
+	h := int64(math.Mod(hours, 24))
+	d := int64(hours / 24)
 	return fmt.Sprintf("%d %s, %d %s",
 		d, Plural("day", d), h, Plural("hour", h))
 }
@@ -101,6 +148,40 @@ func NumberFormat(n int64) string {
 	}
 }
 
+// largeNumber is the value from which numbers are written in scientific notation
+const largeNumber = 1e15
+
+// FloatFormat returns a number-formatted string of a float, eg: 1,123,456,
+// or in scientific notation for very large numbers, eg: 4.6e77
+func FloatFormat(f float64) string {
+	if math.Abs(f) >= largeNumber || math.IsInf(f, 0) || math.IsNaN(f) {
+		return ScientificFormat(f)
+	}
+
+	return NumberFormat(int64(math.Round(f)))
+}
+
+// ScientificFormat returns a float in scientific notation with one decimal, eg: 3.2e9
+func ScientificFormat(f float64) string {
+	s := strconv.FormatFloat(f, 'e', 1, 64)
+	mantissa, exp, ok := strings.Cut(s, "e")
+	if !ok {
+		// Inf or NaN
+		return s
+	}
+	exp = strings.TrimPrefix(exp, "+")
+	neg := strings.HasPrefix(exp, "-")
+	exp = strings.TrimLeft(strings.TrimPrefix(exp, "-"), "0")
+	if exp == "" {
+		return mantissa
+	}
+	if neg {
+		exp = "-" + exp
+	}
+
+	return mantissa + "e" + exp
+}
+
 // IsRegex returns true if any regex meta characters (except +) are in the search term
 func IsRegex(s string) bool {
 	return strings.ContainsAny(s, regexChars)
@@ -190,7 +271,7 @@ func IsValidRegex(s string) string {
 
 // RemoveMetaCharacters removes regex meta characters (except +) from the string
 func removeMetaCharacters(s string) string {
-	// This logic isn't needed anymore, as we don't attempt to calculate the probability of regular expressions
+	// This logic isn't needed anymore, the probability of regular expressions is calculated by RegexProbability
 	// // remove (?i) from beginning of string
 	// re := regexp.MustCompile(`^\([^)]*\)`)
 	// s = re.ReplaceAllLiteralString(s, "")
@@ -1,10 +1,11 @@
 package keygen
 
 import (
+	"context"
 	"encoding/base64"
-	"fmt"
-	"regexp"
-	"strings"
+	"errors"
+	"io"
+	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,10 +19,11 @@ type Options struct {
 	CaseSensitive bool
 	Cores         int
 	Timeout       string
+	Incremental   bool      // step through keys by point addition rather than generating each one
+	UniqueKeys    bool      // never report the same key for more than one search
+	Source        KeySource // randomness private keys are generated from, BufferedCryptoSource if nil
 }
 
-// Cruncher struct
-
 // AtomicCounter struct
 type AtomicCounter struct {
 	Value int64
@@ -40,75 +42,177 @@ func (a *AtomicCounter) Get() int64 {
 // Cruncher struct
 type Cruncher struct {
 	Options
-	WordMap   map[string]*AtomicCounter
-	RegexpMap map[*regexp.Regexp]*AtomicCounter
-	Abort     atomic.Bool // set to true to abort processing
-	timeout   time.Duration
-	timedOut  atomic.Bool
+	Abort    atomic.Bool // set to true to abort processing
+	matchers []Matcher
+	prefixes prefixIndex  // prefix matchers, matched in a single pass
+	raw      []KeyMatcher // other matchers comparing raw key bytes
+	others   []Matcher    // all other matchers, comparing the base64-encoded key
+	limits   []int64      // matches required by each matcher when it was added
+	timeout  time.Duration
+
+	statsMu sync.Mutex
+	started time.Time     // when the current or last search started
+	workers []workerStats // progress of each worker of the current or last search
 }
 
 // Pair struct
 type Pair struct {
-	Private string `json:"private"`
+	Private string `json:"private"` // empty for pairs returned by a search, see PrivateBytes
 	Public  string `json:"public"`
+	Term    string `json:"term,omitempty"`  // name of the search which matched
+	Label   string `json:"label,omitempty"` // label of the search which matched, if any
+
+	secret *Secret // private key in locked memory, see Wipe
 }
 
 // New returns a Cruncher
 func New(options Options, timeout time.Duration) *Cruncher {
 	return &Cruncher{
-		Options:   options,
-		WordMap:   make(map[string]*AtomicCounter),
-		RegexpMap: make(map[*regexp.Regexp]*AtomicCounter),
-		timeout:   timeout,
+		Options: options,
+		timeout: timeout,
+	}
+}
+
+// AddMatcher registers one or more searches. Matchers must be added before Find is called.
+func (c *Cruncher) AddMatcher(m ...Matcher) {
+	c.matchers = append(c.matchers, m...)
+	for _, m := range m {
+		c.limits = append(c.limits, m.Counter().Get())
+		switch m := m.(type) {
+		case *PrefixMatcher:
+			c.prefixes.add(m)
+		case KeyMatcher:
+			c.raw = append(c.raw, m)
+		default:
+			c.others = append(c.others, m)
+		}
 	}
 }
 
-// Crunch will generate a new key and compare to the search(s).
+// Matchers returns the registered searches
+func (c *Cruncher) Matchers() []Matcher {
+	return c.matchers
+}
+
+// Crunch will generate a new key from r and compare to the search(s).
 // buf is a caller-owned scratch buffer of length base64.StdEncoding.EncodedLen(KeySize);
 // passing it in avoids a heap allocation per call.
-func (c *Cruncher) crunch(cb func(match Pair), buf []byte) bool {
-	k, err := newPrivateKey()
+func (c *Cruncher) crunch(r io.Reader, cb func(match Pair), buf []byte) bool {
+	k, err := readPrivateKey(r)
 	if err != nil {
 		panic(err)
 	}
 
 	pubKey := k.Public()
-	base64.StdEncoding.Encode(buf, pubKey[:])
+	completed := c.check(&pubKey, func() PrivateKey { return k }, cb, buf)
+	// the candidate is no longer needed, whether or not it matched
+	k.Wipe()
+
+	return completed
+}
+
+// crunchIncremental will generate the walker's next batch of keys and compare
+// each to the search(s), counting every key compared in attempts. The private key
+// is only recovered for a match. Keys from the same walker are related, as anyone
+// holding one of the private keys could find the others by stepping from it, so
+// the walker is reseeded after every match and the rest of its batch is discarded.
+func (c *Cruncher) crunchIncremental(w *walker, cb func(match Pair), buf []byte, attempts *atomic.Uint64) bool {
+	if err := w.next(); err != nil {
+		panic(err)
+	}
+
+	// keys are counted in bulk, but up to date when a match is reported
+	var counted int
+	count := func(n int) {
+		attempts.Add(uint64(n - counted))
+		counted = n
+	}
 
-	if !c.CaseSensitive {
-		for i, b := range buf {
-			if b >= 'A' && b <= 'Z' {
-				buf[i] = b + 32
+	for i := range w.keys {
+		matched := false
+		private := func() PrivateKey {
+			matched = true
+			count(i + 1)
+			return w.private(i)
+		}
+		if c.check(&w.keys[i], private, cb, buf) {
+			count(i + 1)
+			return true
+		}
+		if matched {
+			if err := w.reseed(); err != nil {
+				panic(err)
 			}
+			return false
 		}
 	}
+	count(len(w.keys))
 
-	// Zero-alloc string view of buf; safe because buf outlives this function
-	// and matchKey is never stored beyond this call.
-	matchKey := unsafe.String(unsafe.SliceData(buf), len(buf))
+	return false
+}
+
+// check compares a public key to the search(s), invoking cb for every search it satisfies.
+// It returns true once all searches have been satisfied. The key is only base64-encoded
+// into buf if a matcher requires it, or once it has matched.
+func (c *Cruncher) check(pubKey *Key, private func() PrivateKey, cb func(match Pair), buf []byte) bool {
+	matched := false
+	emit := func(m Matcher) {
+		matched = true
+		k := private()
+		p := newPair(&k, pubKey, m)
+		k.Wipe()
+		cb(p)
+	}
+
+	c.prefixes.match(pubKey, func(m *PrefixMatcher) {
+		if matched && c.UniqueKeys {
+			return
+		}
+		if c.prefixes.dec(m) {
+			emit(m)
+		}
+	})
 
-	completed := true
+	completed := c.prefixes.completed()
 
-	for w, counter := range c.WordMap {
+	for _, m := range c.raw {
+		counter := m.Counter()
 		if counter.Get() <= 0 {
 			continue
 		}
 		completed = false
-		if strings.HasPrefix(matchKey, w) {
+		if matched && c.UniqueKeys {
+			continue
+		}
+		if m.MatchKey(pubKey) {
 			if counter.Dec() >= 0 {
-				cb(Pair{Private: k.String(), Public: base64.StdEncoding.EncodeToString(pubKey[:])})
+				emit(m)
 			}
 		}
 	}
 
-	for w, counter := range c.RegexpMap {
+	if len(c.others) == 0 {
+		return completed
+	}
+
+	base64.StdEncoding.Encode(buf, pubKey[:])
+
+	// Zero-alloc string view of buf; safe because buf outlives this function
+	// and matchKey is never stored beyond this call.
+	matchKey := unsafe.String(unsafe.SliceData(buf), len(buf))
+
+	for _, m := range c.others {
+		counter := m.Counter()
 		if counter.Get() <= 0 {
 			continue
 		}
 		completed = false
-		if w.MatchString(matchKey) {
+		if matched && c.UniqueKeys {
+			continue
+		}
+		if m.Match(matchKey) {
 			if counter.Dec() >= 0 {
-				cb(Pair{Private: k.String(), Public: base64.StdEncoding.EncodeToString(pubKey[:])})
+				emit(m)
 			}
 		}
 	}
@@ -130,31 +234,38 @@ func (c *Cruncher) CalculateSpeed() (int64, time.Duration) {
 		go func() {
 			defer wg.Done()
 			buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
+			r := c.source().Reader(i)
+			var w *walker
+			if c.Incremental {
+				var err error
+				if w, err = newWalker(r); err != nil {
+					panic(err)
+				}
+			}
 			for {
 				select {
 				case <-done:
 					return
 				default:
 				}
-				k, err := newPrivateKey()
+				if w != nil {
+					if err := w.next(); err != nil {
+						panic(err)
+					}
+					for i := range w.keys {
+						c.probe(&w.keys[i], buf)
+					}
+					atomic.AddInt64(&n, incrementalBatchSize)
+					continue
+				}
+				k, err := readPrivateKey(r)
 				if err != nil {
 					panic(err)
 				}
 				_ = k.String()
 				pubKey := k.Public()
-				base64.StdEncoding.Encode(buf, pubKey[:])
-				for i, b := range buf {
-					if b >= 'A' && b <= 'Z' {
-						buf[i] = b + 32
-					}
-				}
-				t := unsafe.String(unsafe.SliceData(buf), len(buf))
-				for w := range c.WordMap {
-					_ = strings.HasPrefix(t, w)
-				}
-				for w := range c.RegexpMap {
-					_ = w.MatchString(t)
-				}
+				k.Wipe()
+				c.probe(&pubKey, buf)
 				atomic.AddInt64(&n, 1)
 			}
 		}()
@@ -170,89 +281,224 @@ func (c *Cruncher) CalculateSpeed() (int64, time.Duration) {
 	return total / 2, estimate
 }
 
-// CalculateProbability calculates the probability that a string
-// can be found. Case-insensitive letter matches [a-z] can be
-// found in upper and lowercase combinations, so have a higher
-// chance of being found than [0-9], / or +, or case-sensitive matches.
-func CalculateProbability(s string, caseSensitive bool) int64 {
-	var nonAlphaProbability, alphaProbability int64
-	alphaProbability = 26 + 10 + 2
-	nonAlphaProbability = 26 + 26 + 10 + 2
-	if caseSensitive {
-		alphaProbability = nonAlphaProbability
+// probe compares a public key to the search(s) like check does,
+// but without recording matches. It is used to measure speed.
+func (c *Cruncher) probe(pubKey *Key, buf []byte) {
+	c.prefixes.match(pubKey, func(*PrefixMatcher) {})
+	for _, m := range c.raw {
+		_ = m.MatchKey(pubKey)
+	}
+	if len(c.others) == 0 {
+		return
+	}
+	base64.StdEncoding.Encode(buf, pubKey[:])
+	t := unsafe.String(unsafe.SliceData(buf), len(buf))
+	for _, m := range c.others {
+		_ = m.Match(t)
+	}
+}
+
+// CalculateProbability calculates the probability that a literal search term
+// can be found in the given mode, returned as 1 in n. Case-insensitive letter
+// matches [a-z] can be found in upper and lowercase combinations, so have a higher
+// chance of being found than [0-9], / or +, or case-sensitive matches. The final
+// characters of a key can only take a restricted set of values, which affects
+// suffix and contains searches. A return value of 0 means the term can never match.
+// n is a float64, so it is correct for terms of any length, well beyond the
+// range of an int64.
+func CalculateProbability(s string, caseSensitive bool, mode MatchMode) float64 {
+	return oneIn(literalProbability(s, caseSensitive, mode))
+}
+
+// literalProbability returns the probability that a random key contains the
+// literal search term in the given mode
+func literalProbability(s string, caseSensitive bool, mode MatchMode) float64 {
+	sets, ok := charSets(s, caseSensitive)
+	if !ok {
+		return 0
 	}
-	ascii := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	var p int64
-	p = 1
-
-	for _, char := range s {
-		if !strings.Contains(ascii, string(char)) {
-			p = p * nonAlphaProbability
-		} else {
-			p = p * alphaProbability
+
+	switch mode {
+	case MatchSuffix:
+		return windowProbability(sets, keyChars-len(sets))
+	case MatchContains:
+		// the probability of the term not being found at any position, in log
+		// space to keep the precision of tiny probabilities
+		var logMiss float64
+		for start := 0; start+len(sets) <= keyChars; start++ {
+			logMiss += math.Log1p(-windowProbability(sets, start))
 		}
+		return -math.Expm1(logMiss)
+	default:
+		return windowProbability(sets, 0)
+	}
+}
+
+// CalculateRegexProbability calculates the probability that a regular expression
+// matches a key, returned as 1 in n. A return value of 0 means it can never match.
+func CalculateRegexProbability(expr string) (float64, error) {
+	p, err := RegexProbability(expr)
+	if err != nil {
+		return 0, err
 	}
 
-	return p
+	return oneIn(p), nil
+}
+
+// EstimateSeconds returns the expected number of seconds to find a match with
+// a probability of 1 in n, taking speed per key
+func EstimateSeconds(n float64, speed time.Duration) float64 {
+	return n * speed.Seconds()
+}
+
+// oneIn converts the probability p to 1 in n, or 0 if p is 0
+func oneIn(p float64) float64 {
+	if p <= 0 {
+		return 0
+	}
+	return math.Round(1 / p)
 }
 
 // CollectToSlice will run till all the matching keys were calculated. This can take some time
 func (c *Cruncher) CollectToSlice() []Pair {
+	ctx, cancel := c.findContext()
+	defer cancel()
+	matches, _ := c.CollectToSliceContext(ctx)
+	return matches
+}
+
+// CollectToSliceContext will run till all the matching keys were calculated, or ctx is done.
+// The matches found so far are returned along with the error from FindContext.
+func (c *Cruncher) CollectToSliceContext(ctx context.Context) ([]Pair, error) {
+	var mu sync.Mutex
 	var matches []Pair
-	c.Find(func(match Pair) {
+	err := c.FindContext(ctx, func(match Pair) {
+		mu.Lock()
 		matches = append(matches, match)
+		mu.Unlock()
 	})
-	return matches
+	return matches, err
+}
+
+// worker returns the crunch function for worker goroutine i, depending on
+// whether keys are generated incrementally. Every key compared is counted
+// in attempts.
+func (c *Cruncher) worker(i int, attempts *atomic.Uint64) func(cb func(match Pair), buf []byte) bool {
+	r := c.source().Reader(i)
+	if !c.Incremental {
+		return func(cb func(match Pair), buf []byte) bool {
+			attempts.Add(1)
+			return c.crunch(r, cb, buf)
+		}
+	}
+
+	w, err := newWalker(r)
+	if err != nil {
+		panic(err)
+	}
+
+	return func(cb func(match Pair), buf []byte) bool {
+		return c.crunchIncremental(w, cb, buf, attempts)
+	}
+}
+
+// findContext returns the context Find runs with, honouring the timeout passed to New
+func (c *Cruncher) findContext() (context.Context, context.CancelFunc) {
+	if c.timeout == time.Duration(0) {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
 }
 
 // Find will invoke a callback function for each match to support some interactivity or at least feedback
 func (c *Cruncher) Find(cb func(match Pair)) {
-	var wg sync.WaitGroup
+	ctx, cancel := c.findContext()
+	defer cancel()
+	_ = c.FindContext(ctx, cb)
+}
 
-	if c.timeout == time.Duration(0) {
-		for i := 0; i < c.Cores; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
-				for !c.Abort.Load() {
-					if c.crunch(cb, buf) {
-						c.Abort.Store(true)
-						return
-					}
-				}
-			}()
-		}
-		wg.Wait()
-		return
+// FindStatus describes why a search stopped
+type FindStatus int
+
+const (
+	// Finished means all searches were satisfied
+	Finished FindStatus = iota
+	// TimedOut means the context deadline passed
+	TimedOut
+	// Cancelled means the context was cancelled, or Abort was set
+	Cancelled
+)
+
+// String returns the status as a string
+func (s FindStatus) String() string {
+	switch s {
+	case Finished:
+		return "finished"
+	case TimedOut:
+		return "timed out"
+	default:
+		return "cancelled"
+	}
+}
+
+// FindError is returned by FindContext when the search stopped before all searches were satisfied
+type FindError struct {
+	Status FindStatus
+	Err    error // the context's error, if any
+}
+
+// Error returns the error message
+func (e *FindError) Error() string {
+	if e.Err != nil {
+		return "search " + e.Status.String() + ": " + e.Err.Error()
 	}
+	return "search " + e.Status.String()
+}
+
+// Unwrap returns the context's error
+func (e *FindError) Unwrap() error {
+	return e.Err
+}
+
+// FindContext will invoke a callback function for each match until all searches are satisfied,
+// ctx is done, or Abort is set. The callback may be invoked concurrently from multiple goroutines.
+// It returns nil if all searches were satisfied, otherwise a *FindError saying why it stopped.
+func (c *Cruncher) FindContext(ctx context.Context, cb func(match Pair)) error {
+	var wg sync.WaitGroup
+	var stop, finished atomic.Bool
 
-	t := time.NewTimer(c.timeout)
-	defer t.Stop()
+	release := context.AfterFunc(ctx, func() {
+		stop.Store(true)
+	})
+	defer release()
 
+	workers := c.resetStats()
 	for i := 0; i < c.Cores; i++ {
 		wg.Add(1)
-		go func(t *time.Timer) {
+		go func() {
 			defer wg.Done()
 			buf := make([]byte, base64.StdEncoding.EncodedLen(KeySize))
-			for !c.Abort.Load() {
-				if c.crunch(cb, buf) {
-					c.Abort.Store(true)
+			crunch := c.worker(i, &workers[i].attempts)
+			for !stop.Load() && !c.Abort.Load() {
+				if crunch(cb, buf) {
+					finished.Store(true)
+					stop.Store(true)
 					return
 				}
-				select {
-				case <-t.C:
-					c.timedOut.Store(true)
-					c.Abort.Store(true)
-					return
-				default:
-				}
 			}
-		}(t)
+		}()
 	}
 	wg.Wait()
 
-	if c.timedOut.Load() {
-		fmt.Printf("Timed out after %v\n", c.timeout)
+	if finished.Load() {
+		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &FindError{Status: TimedOut, Err: err}
+		}
+		return &FindError{Status: Cancelled, Err: err}
+	}
+
+	return &FindError{Status: Cancelled}
 }
@@ -2,13 +2,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/axllent/ghru/v2"
@@ -51,20 +55,59 @@ func main() {
 		os.Exit(0)
 	}
 
-	var summary, showVersion, update bool
-	var jsonFile string
+	var summary, showVersion, update, suffix, contains, noProgress bool
+	var jsonFile, ndjsonFile, wgQuickDir, networkdDir, nmDir, ifaceName, inventoryFile, termsFile, metricsAddr, serveAddr, coordinatorAddr, workerAddr string
+	var wgConfig keygen.InterfaceConfig
+	var peerSpecs []string
+	var ageRecipients []string
+	var agePassphraseFile, privateDest string
+	var templateFile, templateOut string
+	var allowTTY bool
 	flag.BoolVarP(&summary, "summary", "s", false, "print results when all are found (default false)")
 	flag.BoolVarP(&options.CaseSensitive, "case-sensitive", "c", false, "case sensitive match (default false)")
+	flag.BoolVar(&suffix, "suffix", false, "match search terms at the end of the key (default false)")
+	flag.BoolVar(&contains, "contains", false, "match search terms anywhere in the key (default false)")
+	flag.BoolVarP(&options.Incremental, "incremental", "i", false, "generate keys incrementally by point addition (much faster)")
 	flag.IntVarP(&options.Threads, "threads", "t", options.Cores, "threads")
 	flag.IntVarP(&options.LimitResults, "limit", "l", 1, "limit results to n (exists after)")
 	flag.StringVarP(&options.Timeout, "timeout", "T", "", "quit after n minutes (allowed suffixes: s/m/h) (default \"\")")
 	flag.StringVarP(&jsonFile, "json", "j", "", "write results to JSON file")
+	flag.StringSliceVar(&ageRecipients, "age-recipient", nil, "encrypt result files to age recipient(s), eg: age1...")
+	flag.StringVar(&agePassphraseFile, "age-passphrase-file", "", "encrypt result files with the passphrase read from file")
+	flag.StringVar(&privateDest, "private-out", "", "write private keys to file, directory or fd:N, showing only public keys on stdout")
+	flag.BoolVar(&allowTTY, "allow-tty", false, "allow private keys to be printed to a terminal")
+	flag.StringVar(&templateFile, "template", "", "render each result with a Go text/template file")
+	flag.StringVar(&templateOut, "template-out", "", "append rendered results to file, or write a file per result named by a template, eg: {{.Label}}.conf (default stdout)")
+	flag.StringVar(&ndjsonFile, "ndjson", "", "append each result to file as a line of JSON as soon as it is found (- for stdout)")
+	flag.BoolVar(&noProgress, "no-progress", false, "do not show the live status line (default false)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on address, eg: localhost:9090")
+	flag.StringVar(&termsFile, "terms-file", "", "read search terms from file, one per line (- for stdin)")
+	flag.StringVar(&inventoryFile, "inventory", "", "CSV file of peers to find keys for (name,term,allowed IPs)")
+	flag.StringVar(&wgQuickDir, "wg-quick", "", "write a wg-quick configuration file for each result to directory")
+	flag.StringVar(&networkdDir, "networkd", "", "write systemd-networkd .netdev, .network and key files for each result to directory")
+	flag.StringVar(&nmDir, "nmconnection", "", "write a NetworkManager .nmconnection file for each result to directory")
+	flag.StringVar(&ifaceName, "interface", "", "interface name for configuration files (default label or wg<n>)")
+	flag.StringSliceVar(&wgConfig.Address, "address", nil, "interface address(es) for configuration files, eg: 10.0.0.2/24")
+	flag.IntVar(&wgConfig.ListenPort, "listen-port", 0, "interface listen port for configuration files")
+	flag.StringSliceVar(&wgConfig.DNS, "dns", nil, "DNS server(s) for configuration files")
+	flag.StringArrayVar(&peerSpecs, "peer", nil, "peer for configuration files as key,endpoint,allowed IPs..., eg: <key>,vpn.example.com:51820,10.0.0.0/24")
+	flag.StringVar(&serveAddr, "serve", "", "run a REST API for vanity key jobs on address, eg: localhost:8080")
+	flag.StringVar(&coordinatorAddr, "coordinator", "", "hand the search out to remote workers connecting to address, eg: :7000")
+	flag.StringVar(&workerAddr, "worker", "", "search for the coordinator at address, eg: coordinator:7000")
 	flag.BoolVarP(&showVersion, "version", "v", false, "show app version")
 	flag.BoolVarP(&update, "update", "u", false, "update to latest release")
+	var insecureSeed uint64
+	flag.Uint64Var(&insecureSeed, "insecure-seed", 0, "generate reproducible keys from seed, for demos only")
+	_ = flag.MarkHidden("insecure-seed")
 
 	flag.Parse(os.Args[1:])
 	args := flag.Args()
 
+	if flag.Changed("insecure-seed") {
+		fmt.Fprintf(os.Stderr, "WARNING: keys are generated from seed %d, anyone knowing it can recreate them. Never use them!\n", insecureSeed)
+		options.Source = keygen.InsecureSeededSource{Seed: insecureSeed}
+	}
+
 	if showVersion {
 		fmt.Printf("Version: %s\n", appVersion)
 		release, err := ghruConf.Latest()
@@ -92,7 +135,7 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(args) < 1 {
+	if len(args) < 1 && termsFile == "" && inventoryFile == "" && serveAddr == "" && workerAddr == "" {
 		flag.Usage()
 	}
 
@@ -103,74 +146,236 @@ func main() {
 		options.Cores = options.Threads
 	}
 
+	if serveAddr != "" {
+		if err := serve(serveAddr, options.Cores); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running the API: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	mode := keygen.MatchPrefix
+	if suffix && contains {
+		fmt.Fprintln(os.Stderr, "--suffix and --contains cannot be used together")
+		os.Exit(2)
+	} else if suffix {
+		mode = keygen.MatchSuffix
+	} else if contains {
+		mode = keygen.MatchContains
+	}
+
+	for _, spec := range peerSpecs {
+		peer, err := keygen.ParsePeer(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid interface configuration: %s\n", err)
+			os.Exit(2)
+		}
+		wgConfig.Peers = append(wgConfig.Peers, peer)
+	}
+	if err := wgConfig.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid interface configuration: %s\n", err)
+		os.Exit(2)
+	}
+
+	var configWriters []configWriter
+	if wgQuickDir != "" {
+		configWriters = append(configWriters, wgQuickWriter(wgQuickDir))
+	}
+	if networkdDir != "" {
+		configWriters = append(configWriters, networkdWriter(networkdDir))
+	}
+	if nmDir != "" {
+		configWriters = append(configWriters, nmConnectionWriter(nmDir))
+	}
+
+	var inventory []keygen.InventoryEntry
+	peers := make(map[string]keygen.InventoryEntry)
+	if inventoryFile != "" {
+		f, err := os.Open(filepath.Clean(inventoryFile))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading inventory: %v\n", err)
+			os.Exit(2)
+		}
+		inventory, err = keygen.ParseInventory(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid inventory %s: %v\n", inventoryFile, err)
+			os.Exit(2)
+		}
+		for _, peer := range inventory {
+			peers[peer.Name] = peer
+		}
+		// every peer requires its own key
+		options.UniqueKeys = true
+	}
+
+	defaults := keygen.SearchTerm{
+		CaseSensitive: options.CaseSensitive,
+		Mode:          mode,
+		Limit:         int64(options.LimitResults),
+	}
+	var terms []keygen.SearchTerm
+	if termsFile != "" {
+		var err error
+		terms, err = readTerms(termsFile, defaults)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid terms file %s: %v\n", termsFile, err)
+			os.Exit(2)
+		}
+	}
+
+	enc, err := newEncryptor(ageRecipients, agePassphraseFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	var priv *privateOut
+	if privateDest != "" {
+		if ndjsonFile == "-" {
+			fmt.Fprintln(os.Stderr, "--ndjson - writes private keys to stdout, and cannot be used with --private-out")
+			os.Exit(2)
+		}
+		priv, err = openPrivateOut(privateDest, allowTTY, enc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", privateDest, err)
+			os.Exit(2)
+		}
+	}
+
+	if workerAddr == "" && !allowTTY && isTerminal(os.Stdout) {
+		if out := privateStdout(priv != nil, ndjsonFile, enc != nil, templateFile, templateOut); out != "" {
+			fmt.Fprintf(os.Stderr, "Refusing to print private keys to a terminal (%s): use --private-out to write them elsewhere, or --allow-tty to print them\n", out)
+			os.Exit(2)
+		}
+	}
+
+	var stream *keygen.StreamWriter
+	if ndjsonFile != "" {
+		f, err := openStream(ndjsonFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", ndjsonFile, err)
+			os.Exit(2)
+		}
+		defer func() { _ = f.Close() }()
+		stream = keygen.NewStreamWriter(f)
+		if enc != nil {
+			stream.EncryptTo(enc)
+		}
+	}
+
+	var tmpl *templateOutput
+	if templateFile != "" {
+		if templateOut == "" {
+			templateOut = "-"
+		}
+		if templateOut == "-" && ndjsonFile == "-" {
+			fmt.Fprintln(os.Stderr, "--ndjson - and --template-out - cannot both write to stdout")
+			os.Exit(2)
+		}
+		if templateOut == "-" && priv != nil {
+			fmt.Fprintln(os.Stderr, "--template-out - writes private keys to stdout, and cannot be used with --private-out")
+			os.Exit(2)
+		}
+		tmpl, err = openTemplate(templateFile, templateOut, enc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid template: %v\n", err)
+			os.Exit(2)
+		}
+		if tmpl.f != nil {
+			defer func() { _ = tmpl.f.Close() }()
+		}
+	}
+
 	timeout, err := parseTimeout(options.Timeout)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid timeout value: %s\n", err)
 		os.Exit(2)
 	}
 
+	if workerAddr != "" {
+		if err := runWorker(workerAddr, timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	c := keygen.New(options, timeout)
 
-	fmt.Printf("Calculating speed: ")
+	// the speed of remote workers is unknown
+	var speed time.Duration
+	if coordinatorAddr == "" {
+		fmt.Printf("Calculating speed: ")
 
-	perSecond, speed := c.CalculateSpeed()
-	fmt.Printf("%s calculations per second using %d CPU %s\n", keygen.NumberFormat(perSecond), options.Cores, keygen.Plural("core", int64(options.Cores)))
+		var perSecond int64
+		perSecond, speed = c.CalculateSpeed()
+		fmt.Printf("%s calculations per second using %d CPU %s\n", keygen.NumberFormat(perSecond), options.Cores, keygen.Plural("core", int64(options.Cores)))
+	}
 
 	cs := "insensitive"
 	if options.CaseSensitive {
 		cs = "sensitive"
 	}
+	if mode != keygen.MatchPrefix {
+		cs += " " + mode.String()
+	}
 	fmt.Printf("Case-%s search, exiting after %d %s\n",
 		cs, options.LimitResults, keygen.Plural("result", int64(options.LimitResults)))
 
+	var searches []keygen.SearchTerm
 	for _, word := range args {
-		word = strings.Trim(word, " ")
-		sword := word
-		if !keygen.IsRegex(sword) {
-			if !keygen.IsValidSearch(sword) {
-				fmt.Fprintln(os.Stderr, keygen.InvalidSearchMsg(word))
-				os.Exit(2)
-			}
-			if !options.CaseSensitive {
-				sword = strings.ToLower(sword)
-			}
-			c.WordMap[sword] = &keygen.AtomicCounter{Value: int64(options.LimitResults)}
-			probability := keygen.CalculateProbability(sword, options.CaseSensitive)
-			estimate64 := int64(speed) * probability
-			estimate := time.Duration(estimate64)
-
-			fmt.Printf("Probability for \"%s\": 1 in %s (approx %s per match)\n",
-				word, keygen.NumberFormat(probability), keygen.HumanizeDuration(estimate))
-			continue
+		s, err := keygen.ParseTerm(strings.Trim(word, " "), defaults)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid search: %v\n", err)
+			os.Exit(2)
 		}
+		searches = append(searches, s)
+	}
+	searches = append(searches, terms...)
+	for _, peer := range inventory {
+		s := defaults
+		s.Term = peer.Term
+		s.Limit = 1
+		s.Label = peer.Name
+		searches = append(searches, s)
+	}
 
-		errMsg := keygen.IsValidRegex(sword)
-		if errMsg != "" {
+	for _, s := range searches {
+		if errMsg := addSearch(c, s, speed); errMsg != "" {
 			fmt.Fprintln(os.Stderr, errMsg)
 			os.Exit(2)
 		}
+	}
 
-		fmt.Printf("Probability for \"%s\" cannot be calculated as it is a regular expression\n", sword)
-
-		// strip off leading .* as it's implied:
-		re := regexp.MustCompile(`^\.\*`)
-		sword = re.ReplaceAllLiteralString(sword, "")
-		// strip off trailing .* as it's implied:
-		re = regexp.MustCompile(`\.\*$`)
-		sword = re.ReplaceAllLiteralString(sword, "")
-
-		regex := sword
-		if !options.CaseSensitive {
-			if !strings.HasPrefix(regex, "(?i)") {
-				regex = "(?i)" + regex
-			}
+	find := c.FindContext
+	var progressSource keygen.StatsSource = c
+	var co *keygen.Coordinator
+	if coordinatorAddr != "" {
+		co, err = keygen.NewCoordinator(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
 		}
-		re, err := regexp.Compile(regex)
+		l, err := net.Listen("tcp", coordinatorAddr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "\n\"%s\" is an invalid regular expression: %v\n", word, err)
+			fmt.Fprintf(os.Stderr, "Error starting coordinator: %v\n", err)
 			os.Exit(2)
 		}
-		c.RegexpMap[re] = &keygen.AtomicCounter{Value: int64(options.LimitResults)}
+		fmt.Printf("\nWaiting for workers on %s\n", l.Addr())
+		find = func(ctx context.Context, cb func(match keygen.Pair)) error {
+			return co.Serve(ctx, l, cb)
+		}
+		progressSource = co
+	}
+
+	if metricsAddr != "" {
+		addr, err := serveMetrics(metricsAddr, progressSource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting metrics server: %v\n", err)
+			os.Exit(2)
+		}
+		fmt.Printf("\nServing metrics on http://%s/metrics\n", addr)
 	}
 
 	if timeout > time.Duration(0) {
@@ -179,17 +384,95 @@ func main() {
 
 	fmt.Printf("\nPress Ctrl-c to cancel\n\n")
 
+	ctx := context.Background()
+	if timeout > time.Duration(0) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx, interrupted, stopSignals := handleSignals(ctx, c)
+
+	var status *progress
+	stopProgress := func() {}
+	if !noProgress {
+		status, stopProgress = startProgress(progressSource)
+	}
+
+	printMatch := func(match keygen.Pair) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		status.clear()
+		switch {
+		case priv != nil:
+			if err := priv.write(match); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing private key: %v\n", err)
+				os.Exit(1)
+			}
+			if match.Label != "" {
+				fmt.Printf("public: %s   term: %s   label: %s\n", match.Public, match.Term, match.Label)
+			} else {
+				fmt.Printf("public: %s   term: %s\n", match.Public, match.Term)
+			}
+		case match.Label != "":
+			fmt.Printf("private: %s   public: %s   label: %s\n", match.PrivateBytes(), match.Public, match.Label)
+		default:
+			fmt.Printf("private: %s   public: %s\n", match.PrivateBytes(), match.Public)
+		}
+		if len(configWriters) == 0 {
+			return
+		}
+		cfg := wgConfig
+		if peer, ok := peers[match.Label]; ok && len(peer.AllowedIPs) > 0 {
+			cfg.Address = peer.AllowedIPs
+		}
+		for _, w := range configWriters {
+			files, err := w.write(ifaceName, match, cfg, enc)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s configuration: %v\n", w.format, err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s configuration written to %s\n", w.format, strings.Join(files, ", "))
+		}
+		fmt.Printf("Add to the server configuration:\n\n%s\n", match.PeerConfig(cfg))
+	}
+
 	var results []keygen.Pair
-	if !summary && jsonFile == "" {
-		c.Find(func(match keygen.Pair) {
-			fmt.Printf("private: %s   public: %s\n", match.Private, match.Public)
-		})
-	} else {
-		results = c.CollectToSlice()
-		for _, match := range results {
-			fmt.Printf("private: %s   public: %s\n", match.Private, match.Public)
+	var mu sync.Mutex
+	report := func(match keygen.Pair) {
+		printMatch(match)
+		match.Wipe()
+	}
+	if summary || jsonFile != "" {
+		report = func(match keygen.Pair) {
+			mu.Lock()
+			results = append(results, match)
+			mu.Unlock()
 		}
 	}
+	if stream != nil {
+		report = streamMatches(stream, progressSource, report)
+	}
+	if tmpl != nil {
+		report = templateMatches(tmpl, progressSource, report)
+	}
+	err = find(ctx, report)
+	stopSignals()
+	stopProgress()
+	if co != nil {
+		printThroughput(co)
+	}
+
+	var findErr *keygen.FindError
+	if errors.As(err, &findErr) && findErr.Status == keygen.TimedOut {
+		fmt.Printf("Timed out after %v\n", timeout)
+	}
+	if interrupted() {
+		fmt.Printf("Interrupted, %s\n", unsatisfied(progressSource.Stats()))
+	}
+
+	for _, match := range results {
+		printMatch(match)
+	}
 
 	if jsonFile != "" {
 		jsonFile = filepath.Clean(jsonFile)
@@ -203,12 +486,96 @@ func main() {
 			fmt.Fprintf(os.Stderr, "Error encoding JSON: %v\n", err)
 			os.Exit(1)
 		}
-		if err := os.WriteFile(jsonFile, data, 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing JSON file: %v\n", err)
-			os.Exit(1)
+		if enc != nil {
+			encryptedFile, indexFile, err := writeEncryptedResults(jsonFile, data, results, enc)
+			clear(data)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing JSON file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("\nResults encrypted to %s, public keys written to %s\n", encryptedFile, indexFile)
+		} else {
+			err = writePrivateFile(jsonFile, data)
+			clear(data)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing JSON file: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("\nResults written to %s\n", jsonFile)
 		}
-		fmt.Printf("\nResults written to %s\n", jsonFile)
 	}
+
+	for i := range results {
+		results[i].Wipe()
+	}
+
+	if interrupted() {
+		os.Exit(exitInterrupted)
+	}
+}
+
+// addSearch validates the search and adds it to the cruncher, printing its probability.
+// It returns an error message if the search is invalid.
+func addSearch(c *keygen.Cruncher, s keygen.SearchTerm, speed time.Duration) string {
+	name := s.Term
+	if s.Label != "" {
+		name = fmt.Sprintf("%s (%s)", s.Term, s.Label)
+	}
+
+	m, err := s.Matcher()
+	if err != nil {
+		return "\n" + err.Error()
+	}
+	c.AddMatcher(m)
+
+	probability := keygen.CalculateProbability(s.Term, s.CaseSensitive, s.Mode)
+	if _, ok := m.(*keygen.RegexpMatcher); ok {
+		if probability, err = keygen.CalculateRegexProbability(m.Name()); err != nil {
+			fmt.Printf("Probability for \"%s\" cannot be calculated: %v\n", name, err)
+			return ""
+		}
+	}
+	if speed == 0 {
+		fmt.Printf("Probability for \"%s\": 1 in %s\n", name, keygen.FloatFormat(probability))
+		return ""
+	}
+	estimate := keygen.EstimateSeconds(probability, speed)
+
+	fmt.Printf("Probability for \"%s\": 1 in %s (approx %s per match)\n",
+		name, keygen.FloatFormat(probability), keygen.HumanizeSeconds(estimate))
+	return ""
+}
+
+// newEncryptor returns the encryptor for result files, or nil if they are not encrypted
+func newEncryptor(recipients []string, passphraseFile string) (*keygen.Encryptor, error) {
+	switch {
+	case len(recipients) > 0 && passphraseFile != "":
+		return nil, errors.New("--age-recipient and --age-passphrase-file cannot be used together")
+	case len(recipients) > 0:
+		return keygen.NewEncryptor(recipients...)
+	case passphraseFile != "":
+		b, err := os.ReadFile(filepath.Clean(passphraseFile))
+		if err != nil {
+			return nil, err
+		}
+		return keygen.NewPassphraseEncryptor(strings.TrimRight(string(b), "\r\n"))
+	}
+
+	return nil, nil
+}
+
+// readTerms reads the search terms from a file, or stdin if path is -
+func readTerms(path string, defaults keygen.SearchTerm) ([]keygen.SearchTerm, error) {
+	if path == "-" {
+		return keygen.ParseTerms(os.Stdin, defaults)
+	}
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return keygen.ParseTerms(f, defaults)
 }
 
 // parseTimeout parses the timeout string to a time.Duration. If the input is
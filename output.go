@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+// outputMu serialises output, as matches are reported from multiple goroutines
+var outputMu sync.Mutex
+
+// configFile is a configuration file for an interface
+type configFile struct {
+	ext  string // appended to the interface name to name the file
+	data string
+}
+
+// configWriter writes the configuration files of a match in one format
+type configWriter struct {
+	format string // eg: wg-quick
+	dir    string
+	render func(name string, match keygen.Pair, cfg keygen.InterfaceConfig) []configFile
+}
+
+// wgQuickWriter writes wg-quick configuration files
+func wgQuickWriter(dir string) configWriter {
+	return configWriter{format: "wg-quick", dir: dir, render: func(name string, match keygen.Pair, cfg keygen.InterfaceConfig) []configFile {
+		return []configFile{{".conf", match.WGQuickConfig(cfg)}}
+	}}
+}
+
+// networkdWriter writes systemd-networkd .netdev and .network files, and the private
+// key file the .netdev file reads from /etc/systemd/network
+func networkdWriter(dir string) configWriter {
+	return configWriter{format: "systemd-networkd", dir: dir, render: func(name string, match keygen.Pair, cfg keygen.InterfaceConfig) []configFile {
+		keyFile := "/etc/systemd/network/" + name + ".key"
+		return []configFile{
+			{".netdev", match.NetdevConfig(name, keyFile, cfg)},
+			{".network", match.NetworkConfig(name, cfg)},
+			{".key", string(match.PrivateBytes()) + "\n"},
+		}
+	}}
+}
+
+// nmConnectionWriter writes NetworkManager .nmconnection files
+func nmConnectionWriter(dir string) configWriter {
+	return configWriter{format: "NetworkManager", dir: dir, render: func(name string, match keygen.Pair, cfg keygen.InterfaceConfig) []configFile {
+		return []configFile{{".nmconnection", match.NMConnection(name, cfg)}}
+	}}
+}
+
+// write writes the configuration files for the match to the writer's directory,
+// returning their names. The interface is named name, or after the match's label,
+// with a number appended if its first file already exists. Otherwise the next
+// unused wg<n> name is used. If enc is set, the files are encrypted and .age is
+// appended to their names.
+func (w configWriter) write(name string, match keygen.Pair, cfg keygen.InterfaceConfig, enc *keygen.Encryptor) ([]string, error) {
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	base := fileName(name)
+	if base == "" {
+		base = fileName(match.Label)
+	}
+	for n := 0; ; n++ {
+		iface := fmt.Sprintf("wg%d", n)
+		if base != "" {
+			iface = numberedName(base, n)
+		}
+
+		var written []string
+		for i, cf := range w.render(iface, match, cfg) {
+			file, err := writeConfigFile(filepath.Join(w.dir, iface+cf.ext), cf.data, enc)
+			if i == 0 && errors.Is(err, os.ErrExist) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			written = append(written, file)
+		}
+		if written != nil {
+			return written, nil
+		}
+	}
+}
+
+// writeConfigFile creates file, which must not exist, readable only by the current user.
+// If enc is set, the data is encrypted and .age is appended to the file name.
+func writeConfigFile(file, data string, enc *keygen.Encryptor) (string, error) {
+	plaintext := []byte(data)
+	defer clear(plaintext)
+	b := plaintext
+	if enc != nil {
+		var err error
+		if b, err = enc.Encrypt(plaintext); err != nil {
+			return "", err
+		}
+		file += ".age"
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	return file, f.Close()
+}
+
+// writeEncryptedResults writes the results encrypted to file.age, and the public
+// keys in plaintext to file.public.json, or file.public if it has no .json
+// extension. It returns the names of the files written.
+func writeEncryptedResults(file string, data []byte, results []keygen.Pair, enc *keygen.Encryptor) (string, string, error) {
+	encrypted, err := enc.Encrypt(data)
+	if err != nil {
+		return "", "", err
+	}
+	index, err := keygen.PublicIndex(results)
+	if err != nil {
+		return "", "", err
+	}
+
+	encryptedFile := file + ".age"
+	indexFile := strings.TrimSuffix(file, ".json") + ".public"
+	if strings.HasSuffix(file, ".json") {
+		indexFile += ".json"
+	}
+	if err := writePrivateFile(encryptedFile, encrypted); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(indexFile, index, 0644); err != nil {
+		return "", "", err
+	}
+
+	return encryptedFile, indexFile, nil
+}
+
+// numberedName returns base for the first of several files with the same name,
+// and base-n for the others
+func numberedName(base string, n int) string {
+	if n == 0 {
+		return base
+	}
+	return fmt.Sprintf("%s-%d", base, n)
+}
+
+// writePrivateFile writes data to file, readable only by the current user. The
+// permissions of an existing file are restricted before it is overwritten.
+func writePrivateFile(file string, data []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// fileName returns s with any characters which are not valid in
+// an interface name replaced with underscores
+func fileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_=+.-", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// serveMetrics serves the Prometheus metrics of the search on addr in the background,
+// returning the address listened on
+func serveMetrics(addr string, s keygen.StatsSource) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", keygen.NewMetricsHandler(s))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	return l.Addr().String(), nil
+}
+
+// serve runs the REST API for vanity key jobs on addr, using at most cores CPU cores
+func serve(addr string, cores int) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	svc := keygen.NewService(cores)
+	defer svc.Close()
+
+	fmt.Printf("Serving the API on http://%s/jobs using up to %d CPU %s\n",
+		l.Addr(), cores, keygen.Plural("core", int64(cores)))
+	srv := &http.Server{Handler: svc, ReadHeaderTimeout: 10 * time.Second}
+
+	return srv.Serve(l)
+}
+
+// runWorker searches for the coordinator at addr until it is stopped,
+// or the timeout passes
+func runWorker(addr string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	fmt.Printf("Searching for the coordinator at %s using %d CPU %s\n",
+		addr, options.Cores, keygen.Plural("core", int64(options.Cores)))
+	start := time.Now()
+	attempts, err := keygen.RunWorker(ctx, addr, options)
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Printf("Timed out after %v\n", timeout)
+		err = nil
+	}
+	fmt.Printf("Tried %s keys in %s\n", keygen.FloatFormat(float64(attempts)), time.Since(start).Truncate(time.Second))
+
+	return err
+}
+
+// printThroughput prints the number of keys tried by all workers of the coordinator
+func printThroughput(co *keygen.Coordinator) {
+	s := co.Stats()
+	fmt.Printf("Tried %s keys at %s/s using %d %s with %d CPU %s\n",
+		keygen.FloatFormat(float64(s.Attempts)), keygen.FloatFormat(s.Rate()),
+		len(s.Workers), keygen.Plural("worker", int64(len(s.Workers))),
+		co.Cores(), keygen.Plural("core", int64(co.Cores())))
+}
+
+// openStream opens the file matches are streamed to, appending to it if it exists.
+// The permissions of an existing regular file are restricted to the current user
+// before anything is written to it. With "-", matches are streamed to stdout, and
+// all other output goes to stderr so the stream is not interrupted.
+func openStream(path string) (*os.File, error) {
+	if path == "-" {
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		return stdout, nil
+	}
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// pipes and devices such as /dev/stderr are left alone
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		if err := f.Chmod(0600); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// streamMatches returns a callback writing every match to the stream before passing it on to next
+func streamMatches(stream *keygen.StreamWriter, stats keygen.StatsSource, next func(match keygen.Pair)) func(match keygen.Pair) {
+	return func(match keygen.Pair) {
+		err := stream.Write(keygen.Match{Pair: match, Time: time.Now(), Attempts: stats.Stats().Attempts})
+		if err != nil {
+			outputMu.Lock()
+			fmt.Fprintf(os.Stderr, "Error writing result: %v\n", err)
+			os.Exit(1)
+		}
+		next(match)
+	}
+}
+
+// templateOutput writes matches rendered with a template, to a single file
+// or to a file per match named by the template
+type templateOutput struct {
+	mu  sync.Mutex
+	t   *keygen.Template
+	f   *os.File // every match is written to f, unless the template names a file per match
+	enc *keygen.Encryptor
+}
+
+// openTemplate parses the template file, to be written to out: a single file the
+// results are appended to, - for stdout, or a file name template containing {{ for
+// a file per result. Encrypted results need a file per result.
+func openTemplate(file, out string, enc *keygen.Encryptor) (*templateOutput, error) {
+	text, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	if strings.Contains(out, "{{") {
+		name = out
+	}
+	t, err := keygen.ParseTemplate(string(text), name)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &templateOutput{t: t, enc: enc}
+	if !t.PerMatch() {
+		if enc != nil {
+			return nil, errors.New("encrypting --template output needs a file per result, eg: --template-out '{{.Label}}.conf'")
+		}
+		if o.f, err = openStream(out); err != nil {
+			return nil, err
+		}
+	}
+
+	return o, nil
+}
+
+// write renders the match and writes it out. Files per match must not exist,
+// and are encrypted if enc is set, see writeConfigFile.
+func (o *templateOutput) write(m keygen.Match) error {
+	name, data, err := o.t.Render(m)
+	if err != nil {
+		return err
+	}
+	defer clear(data)
+
+	if o.t.PerMatch() {
+		file := filepath.Clean(name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		_, err := writeConfigFile(file, string(data), o.enc)
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = o.f.Write(data)
+	return err
+}
+
+// templateMatches returns a callback writing every match with the template before passing it on to next
+func templateMatches(out *templateOutput, stats keygen.StatsSource, next func(match keygen.Pair)) func(match keygen.Pair) {
+	return func(match keygen.Pair) {
+		if err := out.write(keygen.Match{Pair: match, Time: time.Now(), Attempts: stats.Stats().Attempts}); err != nil {
+			outputMu.Lock()
+			fmt.Fprintf(os.Stderr, "Error writing template output: %v\n", err)
+			os.Exit(1)
+		}
+		next(match)
+	}
+}
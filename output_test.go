@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+func TestWritePrivateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "results.json")
+	if err := os.WriteFile(file, []byte("an older and longer file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePrivateFile(file, []byte("results")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "results" {
+		t.Errorf("read %q, want %q", b, "results")
+	}
+	if runtime.GOOS != "windows" {
+		if err := checkPrivate(file); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "results.ndjson")
+	if err := os.WriteFile(file, []byte("line 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openStream(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := f.WriteString("line 2\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "line 1\nline 2\n" {
+		t.Errorf("read %q, expected the line to be appended", b)
+	}
+	if runtime.GOOS != "windows" {
+		if err := checkPrivate(file); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTemplateMatchesCoordinator(t *testing.T) {
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "peer.tmpl")
+	if err := os.WriteFile(tmplFile, []byte("{{.Index}} {{.Public}} {{.Attempts}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := openTemplate(tmplFile, filepath.Join(dir, "peers.txt"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = out.f.Close() }()
+
+	c := keygen.New(keygen.Options{}, 0)
+	c.AddMatcher(keygen.NewPrefixMatcher("a", false, 2))
+	co, err := keygen.NewCoordinator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	go func() {
+		_, _ = keygen.RunWorker(ctx, l.Addr().String(), keygen.Options{Cores: 1})
+	}()
+
+	// the callback reads the coordinator's stats for each match
+	errs := make(chan error, 1)
+	go func() {
+		errs <- co.Serve(ctx, l, templateMatches(out, co, func(keygen.Pair) {}))
+	}()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "peers.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "1 ") {
+		t.Errorf("unexpected output:\n%s", b)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+// privateOut is a protected destination for private keys, keeping them off stdout
+type privateOut struct {
+	dir string            // one file per key is written to dir, or
+	f   *os.File          // every key is appended to f
+	enc *keygen.Encryptor // encrypts the files written to dir, if set
+}
+
+// openPrivateOut opens the destination for private keys: fd:N for an open file
+// descriptor, a directory (existing, or ending with a path separator) for a file
+// per key, or otherwise a file the keys are appended to. Files must not be readable
+// by other users, and terminals are refused unless allowTTY is set. If enc is set,
+// the keys are encrypted, which needs a directory.
+func openPrivateOut(dest string, allowTTY bool, enc *keygen.Encryptor) (*privateOut, error) {
+	o, err := openPrivateDest(dest, allowTTY)
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil && o.dir == "" {
+		// open file descriptors are left to their owner
+		if !strings.HasPrefix(dest, "fd:") {
+			_ = o.f.Close()
+		}
+		return nil, errors.New("encrypted private keys are written to a file each, so --private-out must be a directory, eg: keys/")
+	}
+	o.enc = enc
+
+	return o, nil
+}
+
+// openPrivateDest opens the destination for private keys, see openPrivateOut
+func openPrivateDest(dest string, allowTTY bool) (*privateOut, error) {
+	if n, ok := strings.CutPrefix(dest, "fd:"); ok {
+		fd, err := strconv.Atoi(n)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid file descriptor %q", n)
+		}
+		f := os.NewFile(uintptr(fd), dest)
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not open", fd)
+		}
+		if isTerminal(f) && !allowTTY {
+			return nil, fmt.Errorf("file descriptor %d is a terminal, use --allow-tty to print private keys to it", fd)
+		}
+		return &privateOut{f: f}, nil
+	}
+
+	isDir := strings.HasSuffix(dest, string(os.PathSeparator))
+	dest = filepath.Clean(dest)
+	if fi, err := os.Stat(dest); err == nil && fi.IsDir() {
+		isDir = true
+	}
+	if isDir {
+		if err := os.MkdirAll(dest, 0700); err != nil {
+			return nil, err
+		}
+		if err := checkPrivate(dest); err != nil {
+			return nil, err
+		}
+		return &privateOut{dir: dest}, nil
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if isTerminal(f) {
+		if !allowTTY {
+			_ = f.Close()
+			return nil, fmt.Errorf("%s is a terminal, use --allow-tty to print private keys to it", dest)
+		}
+	} else if err := checkPrivate(dest); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &privateOut{f: f}, nil
+}
+
+// checkPrivate returns an error if path can be accessed by other users.
+// Windows does not report access by other users in file modes.
+func checkPrivate(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s can be accessed by other users (mode %v), restrict it with chmod go-rwx", path, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// write writes the private key of the match. In a directory, keys with the same
+// label are numbered, eg: team.key, team-1.key, and .age is appended to their
+// names if they are encrypted.
+func (o *privateOut) write(match keygen.Pair) error {
+	if o.f != nil {
+		var err error
+		if match.Label != "" {
+			_, err = fmt.Fprintf(o.f, "private: %s   public: %s   label: %s\n", match.PrivateBytes(), match.Public, match.Label)
+		} else {
+			_, err = fmt.Fprintf(o.f, "private: %s   public: %s\n", match.PrivateBytes(), match.Public)
+		}
+		return err
+	}
+
+	base := fileName(match.Label)
+	if base == "" {
+		base = fileName(match.Public)
+	}
+	for n := 0; ; n++ {
+		file := filepath.Join(o.dir, numberedName(base, n)+".key")
+		_, err := writeConfigFile(file, string(match.PrivateBytes())+"\n", o.enc)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return err
+	}
+}
+
+// privateStdout returns the output which would print private keys to stdout, if
+// any: the results unless --private-out is given, --ndjson - unless results are
+// encrypted, or --template-out -
+func privateStdout(privateOut bool, ndjsonFile string, encrypted bool, templateFile, templateOut string) string {
+	switch {
+	case !privateOut:
+		return "results"
+	case ndjsonFile == "-" && !encrypted:
+		return "--ndjson -"
+	case templateFile != "" && (templateOut == "" || templateOut == "-"):
+		return "--template-out -"
+	}
+	return ""
+}
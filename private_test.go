@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+var (
+	alice = keygen.Pair{Private: "cHJpdmF0ZSBrZXkgb2YgYWxpY2UhISEhISEhISEhISE=", Public: "YWxpY2UgcHVibGljIGtleSEhISEhISEhISEhISEhISE=", Label: "alice"}
+	bob   = keygen.Pair{Private: "cHJpdmF0ZSBrZXkgb2YgYm9iISEhISEhISEhISEhISE=", Public: "Ym9iL3B1YmxpYyBrZXkhISEhISEhISEhISEhISEhISE="}
+)
+
+func TestPrivateOutDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys") + string(os.PathSeparator)
+	o, err := openPrivateOut(dir, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.dir == "" {
+		t.Fatal("expected a directory")
+	}
+
+	// further keys with the same label are numbered
+	for _, p := range []keygen.Pair{alice, alice, bob} {
+		if err := o.write(p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for file, want := range map[string]string{
+		"alice.key":                   alice.Private,
+		"alice-1.key":                 alice.Private,
+		fileName(bob.Public) + ".key": bob.Private,
+	} {
+		b, err := os.ReadFile(filepath.Join(o.dir, file))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if string(b) != want+"\n" {
+			t.Errorf("%s contains %q, want %q", file, b, want)
+		}
+		if err := checkPrivate(filepath.Join(o.dir, file)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// an existing directory is used without a trailing separator
+	if o, err := openPrivateOut(strings.TrimSuffix(dir, string(os.PathSeparator)), false, nil); err != nil || o.dir == "" {
+		t.Errorf("expected the existing directory, got %+v, %v", o, err)
+	}
+}
+
+func TestPrivateOutFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys.txt")
+	for _, p := range []keygen.Pair{alice, bob} {
+		o, err := openPrivateOut(file, false, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := o.write(p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_ = o.f.Close()
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "private: " + alice.Private + "   public: " + alice.Public + "   label: alice\n" +
+		"private: " + bob.Private + "   public: " + bob.Public + "\n"
+	if string(b) != want {
+		t.Errorf("unexpected file:\n%s\nwant:\n%s", b, want)
+	}
+	if err := checkPrivate(file); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrivateOutEncrypted(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := keygen.NewEncryptor(id.Recipient().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys appended to a single file could not be decrypted
+	if _, err := openPrivateOut(filepath.Join(t.TempDir(), "keys.txt"), false, enc); err == nil {
+		t.Error("expected an error for encrypted keys appended to a file")
+	}
+
+	o, err := openPrivateOut(filepath.Join(t.TempDir(), "keys")+string(os.PathSeparator), false, enc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range []keygen.Pair{alice, alice} {
+		if err := o.write(p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, file := range []string{"alice.key.age", "alice-1.key.age"} {
+		f, err := os.Open(filepath.Join(o.dir, file))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		r, err := age.Decrypt(f, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		_ = f.Close()
+		if err != nil || string(b) != alice.Private+"\n" {
+			t.Errorf("%s decrypted to %q, %v", file, b, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(o.dir, "alice.key")); err == nil {
+		t.Error("expected no plaintext key file")
+	}
+}
+
+func TestPrivateStdout(t *testing.T) {
+	tests := []struct {
+		privateOut  bool
+		ndjson      string
+		encrypted   bool
+		template    string
+		templateOut string
+		want        string
+	}{
+		{false, "", false, "", "", "results"},
+		{true, "", false, "", "", ""},
+		{true, "-", false, "", "", "--ndjson -"},
+		{true, "-", true, "", "", ""},
+		{true, "results.ndjson", false, "", "", ""},
+		{true, "", false, "peer.tmpl", "", "--template-out -"},
+		{true, "", false, "peer.tmpl", "-", "--template-out -"},
+		{true, "", false, "peer.tmpl", "peers.conf", ""},
+	}
+	for _, tt := range tests {
+		if got := privateStdout(tt.privateOut, tt.ndjson, tt.encrypted, tt.template, tt.templateOut); got != tt.want {
+			t.Errorf("privateStdout(%+v) = %q, want %q", tt, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes do not report access by other users on Windows")
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPrivate(file); err == nil {
+		t.Error("expected an error for a file readable by other users")
+	}
+	if _, err := openPrivateOut(file, false, nil); err == nil {
+		t.Error("expected openPrivateOut to refuse a file readable by other users")
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openPrivateOut(dir, false, nil); err == nil {
+		t.Error("expected openPrivateOut to refuse a directory readable by other users")
+	}
+
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkPrivate(file); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkPrivate(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPrivateOutFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+
+	// the descriptor is duplicated, as it is closed by both files
+	fd, err := unix.Dup(int(w.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+	o, err := openPrivateOut("fd:"+strconv.Itoa(fd), false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := o.write(bob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = o.f.Close()
+
+	b := make([]byte, 200)
+	n, _ := r.Read(b)
+	if want := "private: " + bob.Private + "   public: " + bob.Public + "\n"; string(b[:n]) != want {
+		t.Errorf("read %q, want %q", b[:n], want)
+	}
+
+	for _, dest := range []string{"fd:", "fd:x", "fd:-1", "fd:100000"} {
+		if _, err := openPrivateOut(dest, false, nil); err == nil {
+			t.Errorf("openPrivateOut(%q) expected an error", dest)
+		}
+	}
+}
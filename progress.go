@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+// progressInterval is how often the status line is refreshed
+const progressInterval = time.Second
+
+// progressMaxTerms is the number of searches listed individually in the status line,
+// more are summarised to keep it on a single line
+const progressMaxTerms = 3
+
+// progress writes a periodically refreshing status line to a terminal
+type progress struct {
+	c     keygen.StatsSource
+	out   *os.File
+	shown bool         // whether a status line is currently shown
+	last  keygen.Stats // previous snapshot, for the current rate
+}
+
+// startProgress shows a status line on stderr while the search runs,
+// if it is a terminal. It returns a function which removes the status line.
+func startProgress(c keygen.StatsSource) (*progress, func()) {
+	if !isTerminal(os.Stderr) {
+		return nil, func() {}
+	}
+
+	p := &progress{c: c, out: os.Stderr}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+
+	return p, func() {
+		cancel()
+		<-done
+	}
+}
+
+// isTerminal returns true if f is a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// run refreshes the status line until ctx is done
+func (p *progress) run(ctx context.Context) {
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			outputMu.Lock()
+			p.clear()
+			outputMu.Unlock()
+			return
+		case <-t.C:
+			p.update()
+		}
+	}
+}
+
+// update writes the status line with the current progress
+func (p *progress) update() {
+	s := p.c.Stats()
+	rate := s.Rate()
+	if dt := s.Elapsed - p.last.Elapsed; p.last.Elapsed > 0 && dt > 0 {
+		rate = float64(s.Attempts-p.last.Attempts) / dt.Seconds()
+	}
+	p.last = s
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "tried %s keys at %s/s in %s",
+		keygen.FloatFormat(float64(s.Attempts)), keygen.FloatFormat(rate), s.Elapsed.Truncate(time.Second))
+
+	if len(s.Terms) > progressMaxTerms {
+		var complete int
+		for _, t := range s.Terms {
+			if t.Remaining == 0 {
+				complete++
+			}
+		}
+		fmt.Fprintf(&b, " | %d/%d searches complete", complete, len(s.Terms))
+	} else {
+		for _, t := range s.Terms {
+			name := t.Name
+			if t.Label != "" {
+				name = t.Label
+			}
+			fmt.Fprintf(&b, " | %s: %d/%d", name, t.Found, t.Found+t.Remaining)
+			if t.Remaining > 0 && t.Probability > 0 {
+				fmt.Fprintf(&b, " (%.0f%% expected)", t.Likelihood*100)
+			}
+		}
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintf(p.out, "\r\033[K%s", b.String())
+	p.shown = true
+}
+
+// clear removes the status line, so other output starts on a clean line.
+// outputMu must be held.
+func (p *progress) clear() {
+	if p == nil || !p.shown {
+		return
+	}
+	fmt.Fprint(p.out, "\r\033[K")
+	p.shown = false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+// exitInterrupted is the exit code when the search is stopped by SIGINT or SIGTERM
+// before all matches are found, after the results found so far have been written
+const exitInterrupted = 130
+
+// handleSignals stops the search on SIGINT or SIGTERM by setting the cruncher's
+// abort flag and cancelling the returned context, so the workers return and the
+// results found so far can be written. A second signal terminates immediately.
+// The first returned function reports whether the search was interrupted, and
+// the second restores the default behaviour of the signals once the search has
+// returned, so a signal while the results are written is not taken for an
+// interrupted search.
+func handleSignals(ctx context.Context, c *keygen.Cruncher) (context.Context, func() bool, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	var interrupted atomic.Bool
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+			signal.Stop(sigs)
+			return
+		}
+		// restore the default behaviour, so a second signal kills the process
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		interrupted.Store(true)
+		c.Abort.Store(true)
+		cancel()
+	}()
+
+	stop := func() {
+		signal.Stop(sigs)
+		cancel()
+		<-done
+	}
+
+	return ctx, interrupted.Load, stop
+}
+
+// unsatisfied describes the searches that did not find all of their matches
+func unsatisfied(stats keygen.Stats) string {
+	var terms []string
+	for _, t := range stats.Terms {
+		if t.Remaining > 0 {
+			name := t.Name
+			if t.Label != "" {
+				name = t.Label
+			}
+			terms = append(terms, fmt.Sprintf("%s (%d/%d)", name, t.Found, t.Found+t.Remaining))
+		}
+	}
+	if len(terms) == 0 {
+		return "all searches were satisfied"
+	}
+
+	return fmt.Sprintf("%d of %d searches unsatisfied: %s", len(terms), len(stats.Terms), strings.Join(terms, ", "))
+}
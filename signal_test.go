@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/axllent/wireguard-vanity-keygen/keygen"
+)
+
+func TestUnsatisfied(t *testing.T) {
+	tests := []struct {
+		terms []keygen.TermStats
+		want  string
+	}{
+		{nil, "all searches were satisfied"},
+		{[]keygen.TermStats{{Name: "ab", Found: 2}}, "all searches were satisfied"},
+		{
+			[]keygen.TermStats{
+				{Name: "ab", Found: 2},
+				{Name: "cd", Found: 1, Remaining: 2},
+				{Name: "ef", Label: "office", Remaining: 1},
+			},
+			"2 of 3 searches unsatisfied: cd (1/3), office (0/1)",
+		},
+	}
+	for _, tt := range tests {
+		if got := unsatisfied(keygen.Stats{Terms: tt.terms}); got != tt.want {
+			t.Errorf("unsatisfied(%+v) = %q, want %q", tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestHandleSignalsStop(t *testing.T) {
+	c := keygen.New(keygen.Options{}, 0)
+	ctx, interrupted, stop := handleSignals(context.Background(), c)
+	stop()
+	if ctx.Err() == nil {
+		t.Error("expected the context to be cancelled")
+	}
+	if interrupted() || c.Abort.Load() {
+		t.Error("expected the search not to be interrupted")
+	}
+	// stopping twice is harmless
+	stop()
+}